@@ -0,0 +1,53 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+)
+
+// Big5及Big5-HKSCS(HKSCS-2008)编码, 映射表由WHATWG的index-big5.txt生成: Big5只取首字节
+// 0xA1及以上的部分, Big5-HKSCS取全部.
+// HKSCS中0x8862/0x8864/0x88a3/0x88a5对应两个码点的组合字符序列(如Ê̄),
+// 映射表中以 首码点<<32|次码点 表示. 编码时两个码点在同一次调用的输入中才作为一个字符编码,
+// 流式转换中被调用边界分开的组合字符序列逐个码点编码.
+
+// 首字节0x81~0xFE, 次字节0x40~0x7E或0xA1~0xFE
+func decodeBig5(st *codeState, from []byte) (uint64, int, error) {
+        if from[0] < 0x80 {
+                return uint64(from[0]), 1, nil
+        }
+        if from[0] == 0x80 || from[0] == 0xff {
+                return 0, 0, errors.New("非法Big5编码")
+        }
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        if from[1] < 0x40 || (from[1] > 0x7e && from[1] < 0xa1) || from[1] == 0xff {
+                return 0, 0, errors.New("非法Big5编码")
+        }
+        tmpBig5 := uint64(from[0])<<8 | uint64(from[1])
+        if v, ok := st.decTbl[tmpBig5]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpBig5)
+}
+
+func encodeBig5(st *codeState, code uint64, to []byte) (int, error) {
+        if code < 0x80 {
+                if len(to) < 1 {
+                        return 0, errShortBuf
+                }
+                to[0] = byte(code)
+                return 1, nil
+        }
+        tmpBig5, ok := st.encTbl[code]
+        if !ok {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        if len(to) < 2 {
+                return 0, errShortBuf
+        }
+        to[0] = byte(tmpBig5 >> 8)
+        to[1] = byte(tmpBig5)
+        return 2, nil
+}
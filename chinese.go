@@ -0,0 +1,338 @@
+package better
+
+import (
+        "bufio"
+        "errors"
+        "fmt"
+        "io"
+        "os"
+        "path"
+        "sort"
+        "strings"
+        "unicode/utf8"
+)
+
+// 简繁转换: 使用OpenCC格式的字典(每行为"词<TAB>转换结果 [候选...]", 取第一个结果),
+// 按最长匹配逐词转换, 词典中没有的字符不变. 字典不随本库发布, 需使用OpenCC的
+// data/dictionary目录(STCharacters.txt, STPhrases.txt, TWVariants.txt等);
+// 找不到字典或转换器中没有字典时返回错误, 不会不经转换直接输出.
+
+// 一个字典, 键和值均为UTF-8字符串, 每个键可有多个候选值, 转换时取第一个
+type ChineseDict struct {
+        m      map[string][]string
+        maxLen int // 最长的键的字符数
+        grow   int // 转换结果的字符数最多为键的几倍(向上取整)
+}
+
+// 读取OpenCC格式的字典, 空行及#开头的行忽略
+func ReadChineseDict(r io.Reader) (*ChineseDict, error) {
+        d := &ChineseDict{m: make(map[string][]string)}
+        sc := bufio.NewScanner(r)
+        for n := 1; sc.Scan(); n++ {
+                line := strings.TrimRight(sc.Text(), "\r")
+                if line == "" || strings.HasPrefix(line, "#") {
+                        continue
+                }
+                tab := strings.IndexByte(line, '\t')
+                if tab <= 0 {
+                        return nil, fmt.Errorf("第%d行格式错误", n)
+                }
+                values := strings.Fields(line[tab+1:])
+                if len(values) == 0 || !utf8.ValidString(line) {
+                        return nil, fmt.Errorf("第%d行格式错误", n)
+                }
+                d.add(line[:tab], values)
+        }
+        if err := sc.Err(); err != nil {
+                return nil, err
+        }
+        return d, nil
+}
+
+// 读取字典文件
+func LoadChineseDict(filename string) (*ChineseDict, error) {
+        f, err := os.Open(filename)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+        d, err := ReadChineseDict(f)
+        if err != nil {
+                return nil, fmt.Errorf("字典[%s]: %v", filename, err)
+        }
+        return d, nil
+}
+
+// 键重复时保留先出现的
+func (d *ChineseDict) add(key string, values []string) {
+        if _, ok := d.m[key]; ok {
+                return
+        }
+        d.m[key] = values
+        n := utf8.RuneCountInString(key)
+        if n > d.maxLen {
+                d.maxLen = n
+        }
+        if g := (utf8.RuneCountInString(values[0]) + n - 1) / n; g > d.grow {
+                d.grow = g
+        }
+}
+
+// 返回反向字典(各候选值到键), 一个值对应多个键时取按字符串排序最前的键.
+// OpenCC的TWVariantsRev.txt等反向字典在构建时由正向字典生成, 也可用此方法生成.
+func (d *ChineseDict) Reverse() *ChineseDict {
+        keys := make([]string, 0, len(d.m))
+        for k := range d.m {
+                keys = append(keys, k)
+        }
+        // 按键排序, 使结果确定
+        sort.Strings(keys)
+        r := &ChineseDict{m: make(map[string][]string, len(d.m))}
+        for _, k := range keys {
+                for _, v := range d.m[k] {
+                        r.add(v, []string{k})
+                }
+        }
+        return r
+}
+
+// 简繁转换器, 由若干组字典构成. 各组依次作用于整个文本, 组内在所有字典中取最长匹配,
+// 长度相同时取排在前面的字典(通常词组字典在前, 单字字典在后).
+type ChineseConverter struct {
+        groups [][]*ChineseDict
+}
+
+func NewChineseConverter(groups ...[]*ChineseDict) *ChineseConverter {
+        return &ChineseConverter{groups: groups}
+}
+
+// 检查每组至少有一个非空的字典
+func (cc *ChineseConverter) check() error {
+        if len(cc.groups) == 0 {
+                return errors.New("简繁转换器没有字典")
+        }
+        for k, group := range cc.groups {
+                empty := true
+                for _, d := range group {
+                        if d == nil {
+                                return fmt.Errorf("简繁转换器第%d组有nil字典", k+1)
+                        }
+                        if len(d.m) > 0 {
+                                empty = false
+                        }
+                }
+                if empty {
+                        return fmt.Errorf("简繁转换器第%d组没有字典", k+1)
+                }
+        }
+        return nil
+}
+
+// 转换使一个字符最多变为的字符数
+func (cc *ChineseConverter) expand() int {
+        ret := 1
+        for _, group := range cc.groups {
+                g := 1
+                for _, d := range group {
+                        if d.grow > g {
+                                g = d.grow
+                        }
+                }
+                ret *= g
+        }
+        return ret
+}
+
+// OpenCC的转换配置, 各组为字典文件名(不含.txt)
+var g_ChineseConfigs = map[string][][]string{
+        "s2t":   {{"STPhrases", "STCharacters"}},
+        "t2s":   {{"TSPhrases", "TSCharacters"}},
+        "s2tw":  {{"STPhrases", "STCharacters"}, {"TWVariants"}},
+        "s2twp": {{"STPhrases", "STCharacters"}, {"TWPhrases"}, {"TWVariants"}},
+        "s2hk":  {{"STPhrases", "STCharacters"}, {"HKVariants"}},
+        "tw2s":  {{"TWVariantsRevPhrases", "TWVariantsRev"}, {"TSPhrases", "TSCharacters"}},
+        "tw2sp": {{"TWPhrasesRev", "TWVariantsRevPhrases", "TWVariantsRev"}, {"TSPhrases", "TSCharacters"}},
+        "hk2s":  {{"HKVariantsRevPhrases", "HKVariantsRev"}, {"TSPhrases", "TSCharacters"}},
+        "t2tw":  {{"TWVariants"}},
+        "t2hk":  {{"HKVariants"}},
+}
+
+// 地区名称对应的配置
+var g_ChineseRegions = map[string]string{
+        "zh-hans": "t2s",
+        "zh-cn":   "t2s",
+        "zh-hant": "s2t",
+        "zh-tw":   "s2tw",
+        "zh-hk":   "s2hk",
+}
+
+// 按OpenCC的转换配置从dir加载字典, config为s2t, t2s, s2tw, s2twp, s2hk, tw2s, tw2sp,
+// hk2s, t2tw, t2hk之一, 也可使用目标地区名称zh-CN, zh-TW, zh-HK(由简体或繁体转换到该地区的用字).
+// 名称以Rev结尾的字典不存在时由对应的正向字典反转生成, 不存在的词组字典(名称含Phrases)忽略.
+func LoadChineseConverter(dir, config string) (*ChineseConverter, error) {
+        name := strings.ToLower(config)
+        if c, ok := g_ChineseRegions[name]; ok {
+                name = c
+        }
+        groups, ok := g_ChineseConfigs[name]
+        if !ok {
+                return nil, fmt.Errorf("未知简繁转换配置[%s]", config)
+        }
+        cc := new(ChineseConverter)
+        for _, files := range groups {
+                var group []*ChineseDict
+                for _, file := range files {
+                        d, err := loadOpenCCDict(dir, file)
+                        if err != nil {
+                                return nil, err
+                        }
+                        if d != nil {
+                                group = append(group, d)
+                        }
+                }
+                if len(group) == 0 {
+                        // 如s2twp中只有TWPhrases的一组, 缺少时该组不会转换任何字符
+                        return nil, fmt.Errorf("目录[%s]中没有简繁转换字典%s", dir, strings.Join(files, ", "))
+                }
+                cc.groups = append(cc.groups, group)
+        }
+        if err := cc.check(); err != nil {
+                return nil, err
+        }
+        return cc, nil
+}
+
+func loadOpenCCDict(dir, file string) (*ChineseDict, error) {
+        filename := path.Join(dir, file+".txt")
+        if _, err := os.Stat(filename); os.IsNotExist(err) {
+                switch {
+                case strings.HasSuffix(file, "Rev"):
+                        d, err := loadOpenCCDict(dir, strings.TrimSuffix(file, "Rev"))
+                        if d == nil || err != nil {
+                                return nil, err
+                        }
+                        return d.Reverse(), nil
+                case strings.Contains(file, "Phrases"):
+                        return nil, nil
+                default:
+                        return nil, fmt.Errorf("找不到简繁转换字典[%s], 字典不随本库发布, 可使用OpenCC的data/dictionary目录", filename)
+                }
+        }
+        return LoadChineseDict(filename)
+}
+
+// 转换字符串
+func (cc *ChineseConverter) Convert(s string) string {
+        runes := []rune(s)
+        codes := make([]uint64, len(runes))
+        for k, r := range runes {
+                codes[k] = uint64(r)
+        }
+        for _, group := range cc.groups {
+                codes, _ = convertChinese(group, codes)
+        }
+        var sb strings.Builder
+        for _, c := range codes {
+                sb.WriteRune(rune(c))
+        }
+        return sb.String()
+}
+
+// 按最长匹配转换codes, 返回转换结果及各结果码点对应的来源码点范围[start, end),
+// 由同一次匹配生成的码点中只有第一个的范围非空
+func convertChinese(group []*ChineseDict, codes []uint64) ([]uint64, [][2]int) {
+        maxLen := 0
+        for _, d := range group {
+                if d.maxLen > maxLen {
+                        maxLen = d.maxLen
+                }
+        }
+        out := make([]uint64, 0, len(codes))
+        spans := make([][2]int, 0, len(codes))
+        var key []byte
+        for i := 0; i < len(codes); {
+                matched := false
+                // 打包的组合字符序列及非法码点不参与匹配
+                limit := 0
+                for limit < maxLen && i+limit < len(codes) && codes[i+limit] <= utf8.MaxRune {
+                        limit++
+                }
+                for l := limit; l > 0 && !matched; l-- {
+                        key = key[:0]
+                        for _, c := range codes[i : i+l] {
+                                key = utf8.AppendRune(key, rune(c))
+                        }
+                        for _, d := range group {
+                                if l > d.maxLen {
+                                        continue
+                                }
+                                v, ok := d.m[string(key)]
+                                if !ok {
+                                        continue
+                                }
+                                for k, r := range []rune(v[0]) {
+                                        out = append(out, uint64(r))
+                                        if k == 0 {
+                                                spans = append(spans, [2]int{i, i + l})
+                                        } else {
+                                                spans = append(spans, [2]int{i + l, i + l})
+                                        }
+                                }
+                                i += l
+                                matched = true
+                                break
+                        }
+                }
+                if !matched {
+                        out = append(out, codes[i])
+                        spans = append(spans, [2]int{i, i + 1})
+                        i++
+                }
+        }
+        return out, spans
+}
+
+// 转换时进行简繁转换. 以每次调用的输入为单位匹配词组, 跨两次调用的词组按单字转换.
+// 与WithLossReport同时使用时, 被转换的字符均报告为有损.
+// cc为nil或其中没有字典时, 创建转换器返回错误.
+func WithChineseConversion(cc *ChineseConverter) Option {
+        return func(o *options) {
+                if cc == nil {
+                        cc = new(ChineseConverter)
+                }
+                o.chinese = cc
+        }
+}
+
+func chineseStage(cc *ChineseConverter) stage {
+        return func(chars []pivotChar) []pivotChar {
+                for _, group := range cc.groups {
+                        codes := make([]uint64, len(chars))
+                        for k, c := range chars {
+                                codes[k] = c.code
+                        }
+                        codes, spans := convertChinese(group, codes)
+                        out := make([]pivotChar, len(codes))
+                        for k, code := range codes {
+                                start, end := spans[k][0], spans[k][1]
+                                if start == end {
+                                        // 一个词转换为多个字符时, 后面的字符取该词的偏移, 不对应源字节
+                                        prev := out[k-1]
+                                        out[k] = pivotChar{code: code, off: prev.off, decMode: prev.decMode, changed: true}
+                                        continue
+                                }
+                                first, last := chars[start], chars[end-1]
+                                out[k] = pivotChar{
+                                        code:    code,
+                                        off:     first.off,
+                                        n:       last.off + last.n - first.off,
+                                        decMode: first.decMode,
+                                        changed: first.changed || end-start != 1 || code != first.code ||
+                                                (k+1 < len(codes) && spans[k+1][0] == spans[k+1][1]),
+                                }
+                        }
+                        chars = out
+                }
+                return chars
+        }
+}
@@ -0,0 +1,244 @@
+// gennorm 由Unicode字符数据库生成Unicode正规化(NFC/NFKC)使用的数据normdata.go:
+//
+//	gennorm -version 14.0.0 -o normdata.go UnicodeData.txt CompositionExclusions.txt
+//
+// 分解映射在生成时完全展开, 运行时不需要递归分解; 韩文音节按算法分解及合成, 不在数据中.
+package main
+
+import (
+        "bufio"
+        "bytes"
+        "flag"
+        "fmt"
+        "go/format"
+        "os"
+        "sort"
+        "strconv"
+        "strings"
+)
+
+var (
+        version = flag.String("version", "", "Unicode版本, 写入生成的文件")
+        output  = flag.String("o", "normdata.go", "输出文件")
+)
+
+type decomp struct {
+        compat bool
+        codes  []uint64
+}
+
+func main() {
+        flag.Parse()
+        if flag.NArg() != 2 {
+                fmt.Fprintln(os.Stderr, "用法: gennorm -version 版本 -o normdata.go UnicodeData.txt CompositionExclusions.txt")
+                os.Exit(2)
+        }
+        ccc, decomps, err := readUnicodeData(flag.Arg(0))
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "gennorm: %v\n", err)
+                os.Exit(1)
+        }
+        excl, err := readExclusions(flag.Arg(1))
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "gennorm: %v\n", err)
+                os.Exit(1)
+        }
+
+        // 完全展开的规范分解及兼容分解
+        var expand func(c uint64, compat bool) []uint64
+        expand = func(c uint64, compat bool) []uint64 {
+                d, ok := decomps[c]
+                if !ok || (d.compat && !compat) {
+                        return []uint64{c}
+                }
+                var out []uint64
+                for _, x := range d.codes {
+                        out = append(out, expand(x, compat)...)
+                }
+                return out
+        }
+        canon := make(map[uint64][]uint64)
+        compat := make(map[uint64][]uint64)
+        for c := range decomps {
+                nfd := expand(c, false)
+                if len(nfd) != 1 || nfd[0] != c {
+                        canon[c] = nfd
+                }
+                nfkd := expand(c, true)
+                if !equal(nfkd, nfd) {
+                        compat[c] = nfkd
+                }
+        }
+
+        // 可合成的字符: 规范分解为两个字符, 不是单字符分解, 首字符为起始字符, 且不在排除表中
+        comp := make(map[uint64]uint64)
+        for c, d := range decomps {
+                if d.compat || len(d.codes) != 2 || excl[c] || ccc[c] != 0 || ccc[d.codes[0]] != 0 {
+                        continue
+                }
+                comp[d.codes[0]<<32|d.codes[1]] = c
+        }
+
+        var buf bytes.Buffer
+        fmt.Fprintf(&buf, "package better\n\n")
+        fmt.Fprintf(&buf, "// 由cmd/gennorm根据Unicode %s的UnicodeData.txt及CompositionExclusions.txt生成, 请勿手工修改\n\n", *version)
+        fmt.Fprintf(&buf, "// 非零的规范组合类\nvar g_CombiningClass = map[uint64]uint8{\n")
+        writeEntries(&buf, keys8(ccc), func(k uint64) string { return strconv.Itoa(int(ccc[k])) })
+        fmt.Fprintf(&buf, "}\n\n// 完全展开的规范分解\nvar g_Decomposition = map[uint64]string{\n")
+        writeEntries(&buf, keysSlice(canon), func(k uint64) string { return quote(canon[k]) })
+        fmt.Fprintf(&buf, "}\n\n// 与规范分解不同的完全展开的兼容分解\nvar g_CompatDecomposition = map[uint64]string{\n")
+        writeEntries(&buf, keysSlice(compat), func(k uint64) string { return quote(compat[k]) })
+        fmt.Fprintf(&buf, "}\n\n// 规范合成, 键为 首码点<<32|次码点\nvar g_Composition = map[uint64]uint64{\n")
+        writeEntries(&buf, keysU64(comp), func(k uint64) string { return fmt.Sprintf("0x%04X", comp[k]) })
+        fmt.Fprintf(&buf, "}\n")
+
+        src, err := format.Source(buf.Bytes())
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "gennorm: %v\n", err)
+                os.Exit(1)
+        }
+        if err := os.WriteFile(*output, src, 0644); err != nil {
+                fmt.Fprintf(os.Stderr, "gennorm: %v\n", err)
+                os.Exit(1)
+        }
+}
+
+// UnicodeData.txt的第1, 4, 6列分别为码点, 规范组合类及分解映射
+func readUnicodeData(name string) (map[uint64]uint8, map[uint64]decomp, error) {
+        f, err := os.Open(name)
+        if err != nil {
+                return nil, nil, err
+        }
+        defer f.Close()
+        ccc := make(map[uint64]uint8)
+        decomps := make(map[uint64]decomp)
+        sc := bufio.NewScanner(f)
+        for n := 1; sc.Scan(); n++ {
+                fields := strings.Split(sc.Text(), ";")
+                if len(fields) < 6 {
+                        continue
+                }
+                c, err := strconv.ParseUint(fields[0], 16, 64)
+                if err != nil {
+                        return nil, nil, fmt.Errorf("%s第%d行: %v", name, n, err)
+                }
+                if v, err := strconv.Atoi(fields[3]); err != nil {
+                        return nil, nil, fmt.Errorf("%s第%d行: %v", name, n, err)
+                } else if v != 0 {
+                        ccc[c] = uint8(v)
+                }
+                if fields[5] == "" {
+                        continue
+                }
+                var d decomp
+                for _, s := range strings.Fields(fields[5]) {
+                        if strings.HasPrefix(s, "<") {
+                                d.compat = true
+                                continue
+                        }
+                        x, err := strconv.ParseUint(s, 16, 64)
+                        if err != nil {
+                                return nil, nil, fmt.Errorf("%s第%d行: %v", name, n, err)
+                        }
+                        d.codes = append(d.codes, x)
+                }
+                decomps[c] = d
+        }
+        return ccc, decomps, sc.Err()
+}
+
+func readExclusions(name string) (map[uint64]bool, error) {
+        f, err := os.Open(name)
+        if err != nil {
+                return nil, err
+        }
+        defer f.Close()
+        excl := make(map[uint64]bool)
+        sc := bufio.NewScanner(f)
+        for n := 1; sc.Scan(); n++ {
+                line := sc.Text()
+                if i := strings.IndexByte(line, '#'); i >= 0 {
+                        line = line[:i]
+                }
+                line = strings.TrimSpace(line)
+                if line == "" {
+                        continue
+                }
+                c, err := strconv.ParseUint(line, 16, 64)
+                if err != nil {
+                        return nil, fmt.Errorf("%s第%d行: %v", name, n, err)
+                }
+                excl[c] = true
+        }
+        return excl, sc.Err()
+}
+
+func equal(a, b []uint64) bool {
+        if len(a) != len(b) {
+                return false
+        }
+        for i := range a {
+                if a[i] != b[i] {
+                        return false
+                }
+        }
+        return true
+}
+
+func quote(codes []uint64) string {
+        var sb strings.Builder
+        sb.WriteByte('"')
+        for _, c := range codes {
+                if c > 0xffff {
+                        fmt.Fprintf(&sb, `\U%08X`, c)
+                } else {
+                        fmt.Fprintf(&sb, `\u%04X`, c)
+                }
+        }
+        sb.WriteByte('"')
+        return sb.String()
+}
+
+// 每行写4项
+func writeEntries(buf *bytes.Buffer, keys []uint64, value func(k uint64) string) {
+        for i, k := range keys {
+                if i%4 == 0 {
+                        buf.WriteByte('\t')
+                }
+                fmt.Fprintf(buf, "0x%04X: %s,", k, value(k))
+                if i%4 == 3 || i == len(keys)-1 {
+                        buf.WriteByte('\n')
+                } else {
+                        buf.WriteByte(' ')
+                }
+        }
+}
+
+func sortKeys(keys []uint64) []uint64 {
+        sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+        return keys
+}
+
+func keys8(m map[uint64]uint8) []uint64 {
+        var keys []uint64
+        for k := range m {
+                keys = append(keys, k)
+        }
+        return sortKeys(keys)
+}
+
+func keysSlice(m map[uint64][]uint64) []uint64 {
+        var keys []uint64
+        for k := range m {
+                keys = append(keys, k)
+        }
+        return sortKeys(keys)
+}
+
+func keysU64(m map[uint64]uint64) []uint64 {
+        var keys []uint64
+        for k := range m {
+                keys = append(keys, k)
+        }
+        return sortKeys(keys)
+}
@@ -0,0 +1,448 @@
+// gentables 由标准的映射数据生成本库使用的映射表文件:
+//
+//	gentables -name 字符集 [-format txt|whatwg|ranges|ucm] [-cols 1,2] [-pointer 方案] [-ascii] -dec 文件 -enc 文件 源文件[=方案]...
+//
+// 支持的源文件格式:
+//
+//	txt     Unicode联盟的映射文件(如CP936.TXT), 每行为"0x编码 0xUnicode", #后为注释; -cols指定两列的位置.
+//	        源文件以"=方案"指定了方案时编码列按方案换算(如JIS0201.TXT中的半角片假名换算为EUC-JP中0x8E引导的编码)
+//	whatwg  WHATWG的index文件(如index-gb18030.txt), 每行为"序号 0xUnicode", 序号按"=方案"或-pointer指定的方案换算为编码
+//	ranges  WHATWG的index-gb18030-ranges.txt, 每行为一段连续映射的起始"序号 0xUnicode", 到下一行的序号为止
+//	ucm     ICU的UCM文件, 取CHARMAP段; |0双向, |1只用于编码, |3只用于解码, |2忽略
+//
+// 未指定-format时根据文件名推断(.ucm为ucm, index-开头-ranges.txt结尾为ranges, 其它index-开头为whatwg,
+// 其它为txt). 方案不适用的序号(如euc-jp方案中超出94×94的序号)跳过.
+// 多个源文件依次合并, 解码表中后面的覆盖前面的. 一个码点对应多个编码时编码表使用最小的编码,
+// 与加载单字节映射表时生成反向映射的规则相同; Big5的index按Encoding标准的规则选择(见big5Encode). 两个码点的组合字符序列以 首码点<<32|次码点 表示.
+// -dec输出编码到Unicode的映射表, -enc输出Unicode到编码的映射表, 可只指定其一.
+// 输出为table.go中说明的映射表文件格式, 相同的输入总是生成相同的文件.
+package main
+
+import (
+        "bufio"
+        "bytes"
+        "flag"
+        "fmt"
+        "os"
+        "path/filepath"
+        "sort"
+        "strconv"
+        "strings"
+
+        iconv "github.com/hwch/iconv"
+)
+
+var (
+        charset = flag.String("name", "", "映射表中记录的字符集名称, 须与库中使用该映射表的字符集一致(如GBK)")
+        format  = flag.String("format", "", "源文件格式: txt, whatwg或ucm, 默认根据文件名推断")
+        cols    = flag.String("cols", "1,2", "txt格式中编码及Unicode所在的列(从1开始)")
+        pointer = flag.String("pointer", "", "whatwg及ranges格式的序号换算方案, 或txt格式的编码换算方案: "+strings.Join(schemeNames(), ", "))
+        ascii   = flag.Bool("ascii", false, "补充0x00~0x7F与ASCII相同的映射")
+        decFile = flag.String("dec", "", "输出的编码到Unicode映射表文件")
+        encFile = flag.String("enc", "", "输出的Unicode到编码映射表文件")
+)
+
+// 一条映射及其适用的方向
+type mapping struct {
+        code    uint64
+        unicode uint64
+        dec     bool
+        enc     bool
+}
+
+func main() {
+        flag.Parse()
+        if flag.NArg() == 0 || *charset == "" || (*decFile == "" && *encFile == "") {
+                fmt.Fprintln(os.Stderr, "用法: gentables -name 字符集 [选项] -dec 文件 -enc 文件 源文件[=方案]...")
+                flag.PrintDefaults()
+                os.Exit(2)
+        }
+        var all []mapping
+        if *ascii {
+                for c := uint64(0); c < 0x80; c++ {
+                        all = append(all, mapping{c, c, true, true})
+                }
+        }
+        for _, arg := range flag.Args() {
+                ms, err := readSource(arg)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "gentables: %s: %v\n", arg, err)
+                        os.Exit(1)
+                }
+                all = append(all, ms...)
+        }
+        dec, enc := build(all)
+        if *decFile != "" {
+                if err := writeTable(*decFile, iconv.TableDecode, dec); err != nil {
+                        fmt.Fprintf(os.Stderr, "gentables: %v\n", err)
+                        os.Exit(1)
+                }
+        }
+        if *encFile != "" {
+                if err := writeTable(*encFile, iconv.TableEncode, enc); err != nil {
+                        fmt.Fprintf(os.Stderr, "gentables: %v\n", err)
+                        os.Exit(1)
+                }
+        }
+}
+
+func readSource(arg string) ([]mapping, error) {
+        name, scheme := arg, ""
+        if i := strings.LastIndex(arg, "="); i > 0 {
+                name, scheme = arg[:i], arg[i+1:]
+        }
+        f := *format
+        if f == "" {
+                base := filepath.Base(name)
+                switch {
+                case strings.HasSuffix(base, ".ucm"):
+                        f = "ucm"
+                case strings.HasPrefix(base, "index-") && strings.HasSuffix(base, "-ranges.txt"):
+                        f = "ranges"
+                case strings.HasPrefix(base, "index-"):
+                        f = "whatwg"
+                default:
+                        f = "txt"
+                }
+        }
+        data, err := os.ReadFile(name)
+        if err != nil {
+                return nil, err
+        }
+        if scheme == "" && (f == "whatwg" || f == "ranges") {
+                scheme = *pointer
+        }
+        var conv schemeFunc
+        if scheme != "" || f == "whatwg" || f == "ranges" {
+                var ok bool
+                if conv, ok = g_Schemes[scheme]; !ok {
+                        return nil, fmt.Errorf("未知的序号换算方案[%s]", scheme)
+                }
+        }
+        switch f {
+        case "txt":
+                return readTXT(data, conv)
+        case "whatwg":
+                ms, err := readWHATWG(data, conv)
+                if scheme == "big5" || scheme == "big5-a1" {
+                        big5Encode(ms)
+                }
+                return ms, err
+        case "ranges":
+                return readRanges(data, conv)
+        case "ucm":
+                return readUCM(data)
+        }
+        return nil, fmt.Errorf("未知的源文件格式[%s]", f)
+}
+
+// 解码表中同一编码出现多次时后出现的覆盖先出现的; 编码表中双向映射优先于只用于编码的映射, 其次取最小的编码
+func build(all []mapping) (dec, enc map[uint64]uint64) {
+        dec = make(map[uint64]uint64)
+        enc = make(map[uint64]uint64)
+        rt := make(map[uint64]bool) // 编码表中来自双向映射的码点, 优先于只用于编码的映射
+        for _, m := range all {
+                if m.dec {
+                        dec[m.code] = m.unicode
+                }
+        }
+        for _, m := range all {
+                if !m.enc {
+                        continue
+                }
+                old, ok := enc[m.unicode]
+                switch {
+                case !ok, m.dec && !rt[m.unicode], m.dec == rt[m.unicode] && m.code < old:
+                        enc[m.unicode] = m.code
+                        rt[m.unicode] = m.dec
+                }
+        }
+        return dec, enc
+}
+
+func parseHex(s string) (uint64, error) {
+        s = strings.TrimPrefix(strings.TrimPrefix(s, "0x"), "0X")
+        return strconv.ParseUint(s, 16, 64)
+}
+
+// Unicode码点可写作"0x0041+0x030A"表示组合字符序列
+func parseUnicode(s string) (uint64, error) {
+        parts := strings.Split(s, "+")
+        if len(parts) > 2 {
+                return 0, fmt.Errorf("不支持超过两个码点的字符序列[%s]", s)
+        }
+        var code uint64
+        for _, p := range parts {
+                v, err := parseHex(p)
+                if err != nil {
+                        return 0, err
+                }
+                code = code<<32 | v
+        }
+        return code, nil
+}
+
+// conv不为nil时编码列按conv换算, 不适用的编码跳过
+func readTXT(data []byte, conv schemeFunc) ([]mapping, error) {
+        var ci, ui int
+        if _, err := fmt.Sscanf(*cols, "%d,%d", &ci, &ui); err != nil || ci < 1 || ui < 1 {
+                return nil, fmt.Errorf("-cols格式错误[%s]", *cols)
+        }
+        var ms []mapping
+        sc := bufio.NewScanner(bytes.NewReader(data))
+        for n := 1; sc.Scan(); n++ {
+                line := sc.Text()
+                if i := strings.IndexByte(line, '#'); i >= 0 {
+                        line = line[:i]
+                }
+                fields := strings.Fields(line)
+                if len(fields) == 0 {
+                        continue
+                }
+                // 缺少Unicode列的行表示该编码未定义
+                if len(fields) < ci || len(fields) < ui {
+                        continue
+                }
+                code, err := parseHex(fields[ci-1])
+                if err != nil {
+                        return nil, fmt.Errorf("第%d行: %v", n, err)
+                }
+                unicode, err := parseUnicode(fields[ui-1])
+                if err != nil {
+                        return nil, fmt.Errorf("第%d行: %v", n, err)
+                }
+                if conv != nil {
+                        var ok bool
+                        if code, ok = conv(code); !ok {
+                                continue
+                        }
+                }
+                ms = append(ms, mapping{code, unicode, true, true})
+        }
+        return ms, sc.Err()
+}
+
+func readWHATWG(data []byte, conv schemeFunc) ([]mapping, error) {
+        var ms []mapping
+        err := scanIndex(data, func(p, unicode uint64) {
+                if code, ok := conv(p); ok {
+                        ms = append(ms, mapping{code, unicode, true, true})
+                }
+        })
+        return ms, err
+}
+
+// 每行为一段的起始, 段内序号与码点同步递增, 到下一行的序号为止; 最后一行只是一段的起始, 不产生映射
+func readRanges(data []byte, conv schemeFunc) ([]mapping, error) {
+        var starts [][2]uint64
+        if err := scanIndex(data, func(p, unicode uint64) {
+                starts = append(starts, [2]uint64{p, unicode})
+        }); err != nil {
+                return nil, err
+        }
+        var ms []mapping
+        for i := 0; i+1 < len(starts); i++ {
+                for p := starts[i][0]; p < starts[i+1][0]; p++ {
+                        if code, ok := conv(p); ok {
+                                ms = append(ms, mapping{code, starts[i][1] + p - starts[i][0], true, true})
+                        }
+                }
+        }
+        return ms, nil
+}
+
+// 逐行解析WHATWG index文件中的"序号 0xUnicode"
+func scanIndex(data []byte, fn func(p, unicode uint64)) error {
+        sc := bufio.NewScanner(bytes.NewReader(data))
+        for n := 1; sc.Scan(); n++ {
+                line := sc.Text()
+                if i := strings.IndexByte(line, '#'); i >= 0 {
+                        line = line[:i]
+                }
+                fields := strings.Fields(line)
+                if len(fields) == 0 {
+                        continue
+                }
+                if len(fields) < 2 {
+                        return fmt.Errorf("第%d行: 格式错误", n)
+                }
+                p, err := strconv.ParseUint(fields[0], 10, 64)
+                if err != nil {
+                        return fmt.Errorf("第%d行: %v", n, err)
+                }
+                unicode, err := parseHex(fields[1])
+                if err != nil {
+                        return fmt.Errorf("第%d行: %v", n, err)
+                }
+                fn(p, unicode)
+        }
+        return sc.Err()
+}
+
+// 序号(txt格式中为编码列)到编码的换算, 序号不适用于该方案时返回false
+type schemeFunc func(p uint64) (uint64, bool)
+
+// WHATWG index的序号到编码的换算, 见Encoding标准中各编码的解码算法
+var g_Schemes = map[string]schemeFunc{
+        // 每个首字节190个次字节, 次字节跳过0x7F
+        "gb18030": func(p uint64) (uint64, bool) {
+                lead, trail := p/190+0x81, p%190
+                if trail < 0x3f {
+                        return lead<<8 | (trail + 0x40), lead < 0xff
+                }
+                return lead<<8 | (trail + 0x41), lead < 0xff
+        },
+        // GB18030四字节编码按序号顺序排列, 第一, 三字节0x81~0xFE, 第二, 四字节0x30~0x39; 只取BMP部分
+        "gb18030-ranges": func(p uint64) (uint64, bool) {
+                b1, b2, b3, b4 := p/12600+0x81, p/1260%10+0x30, p/10%126+0x81, p%10+0x30
+                return b1<<24 | b2<<16 | b3<<8 | b4, p < 39420
+        },
+        "big5": big5Pointer,
+        // 同big5, 但不含首字节0x81~0xA0的部分(HKSCS在标准Big5之前增加的编码)
+        "big5-a1": func(p uint64) (uint64, bool) {
+                code, ok := big5Pointer(p)
+                return code, ok && code >= 0xa100
+        },
+        // 每个首字节188个次字节, 首字节跳过0xA0~0xDF, 次字节跳过0x7F
+        "shift_jis": func(p uint64) (uint64, bool) {
+                lead, trail := p/188, p%188
+                if lead < 0x1f {
+                        lead += 0x81
+                } else {
+                        lead += 0xc1
+                }
+                if trail < 0x3f {
+                        return lead<<8 | (trail + 0x40), lead < 0xfd
+                }
+                return lead<<8 | (trail + 0x41), lead < 0xfd
+        },
+        // 每个首字节190个次字节, 首字节从0x81开始, 次字节从0x41开始
+        "euc-kr": func(p uint64) (uint64, bool) {
+                return (p/190+0x81)<<8 | (p%190 + 0x41), p < 126*190
+        },
+        // JIS X 0208按94×94排列, EUC-JP中首字节及次字节均加0xA1; 超出94×94的NEC/IBM扩展字符在EUC-JP中没有编码
+        "euc-jp": func(p uint64) (uint64, bool) {
+                return (p/94+0xa1)<<8 | (p%94 + 0xa1), p < 94*94
+        },
+        // JIS X 0212在EUC-JP中以0x8F开头的三字节表示
+        "jis0212": func(p uint64) (uint64, bool) {
+                return 0x8f<<16 | (p/94+0xa1)<<8 | (p%94 + 0xa1), p < 94*94
+        },
+        // 单字节编码的index只包含0x80~0xFF
+        "single-byte": func(p uint64) (uint64, bool) {
+                return p + 0x80, p < 0x80
+        },
+        // txt格式: JIS0201.TXT中只取半角片假名0xA1~0xDF, Shift_JIS中为单字节
+        "jis0201-kana": func(p uint64) (uint64, bool) {
+                return p, p >= 0xa1 && p <= 0xdf
+        },
+        // txt格式: 同jis0201-kana, EUC-JP中以0x8E引导
+        "jis0201-kana-euc": func(p uint64) (uint64, bool) {
+                return 0x8e<<8 | p, p >= 0xa1 && p <= 0xdf
+        },
+}
+
+// Encoding标准中Big5编码时取最后一个序号的码点, 其余码点取第一个序号
+var g_Big5Last = map[uint64]bool{0x2550: true, 0x255e: true, 0x2561: true, 0x256a: true, 0x5341: true, 0x5345: true}
+
+// 按Encoding标准选择Big5编码时使用的映射, 其余的只用于解码. 与标准不同的是首字节0x81~0xA0(HKSCS)的
+// 编码在码点没有其它编码时也用于编码, 否则Big5-HKSCS无法编码这些字符
+func big5Encode(ms []mapping) {
+        use := make(map[uint64]int) // 码点用于编码的映射在ms中的下标
+        for i, m := range ms {
+                j, ok := use[m.unicode]
+                switch {
+                case !ok, ms[j].code < 0xa100 && m.code >= 0xa100, g_Big5Last[m.unicode] && m.code >= 0xa100:
+                        use[m.unicode] = i
+                }
+        }
+        for i := range ms {
+                ms[i].enc = use[ms[i].unicode] == i
+        }
+}
+
+// 每个首字节157个次字节, 次字节为0x40~0x7E及0xA1~0xFE
+func big5Pointer(p uint64) (uint64, bool) {
+        lead, trail := p/157+0x81, p%157
+        if trail < 0x3f {
+                return lead<<8 | (trail + 0x40), lead < 0xff
+        }
+        return lead<<8 | (trail + 0x62), lead < 0xff
+}
+
+func schemeNames() []string {
+        var names []string
+        for name := range g_Schemes {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        return names
+}
+
+// UCM中的映射行形如"<U4E02> \x81\x40 |0", 组合字符序列形如"<U00CA><U0304> \x88\x62 |0"
+func readUCM(data []byte) ([]mapping, error) {
+        var ms []mapping
+        in := false
+        sc := bufio.NewScanner(bytes.NewReader(data))
+        for n := 1; sc.Scan(); n++ {
+                line := strings.TrimSpace(sc.Text())
+                if i := strings.IndexByte(line, '#'); i >= 0 {
+                        line = strings.TrimSpace(line[:i])
+                }
+                switch {
+                case line == "CHARMAP":
+                        in = true
+                        continue
+                case line == "END CHARMAP":
+                        in = false
+                        continue
+                case !in || line == "":
+                        continue
+                }
+                fields := strings.Fields(line)
+                if len(fields) < 2 {
+                        return nil, fmt.Errorf("第%d行: 格式错误", n)
+                }
+                var unicode uint64
+                us := strings.Split(strings.TrimSuffix(strings.TrimPrefix(fields[0], "<U"), ">"), "><U")
+                if len(us) > 2 {
+                        continue
+                }
+                for _, u := range us {
+                        v, err := strconv.ParseUint(u, 16, 64)
+                        if err != nil {
+                                return nil, fmt.Errorf("第%d行: %v", n, err)
+                        }
+                        unicode = unicode<<32 | v
+                }
+                var code uint64
+                for _, b := range strings.Split(fields[1], `\x`)[1:] {
+                        v, err := strconv.ParseUint(b, 16, 8)
+                        if err != nil {
+                                return nil, fmt.Errorf("第%d行: %v", n, err)
+                        }
+                        code = code<<8 | v
+                }
+                m := mapping{code, unicode, true, true}
+                if len(fields) > 2 {
+                        switch fields[2] {
+                        case "|1":
+                                m.dec = false
+                        case "|3":
+                                m.enc = false
+                        case "|2":
+                                continue
+                        }
+                }
+                ms = append(ms, m)
+        }
+        return ms, sc.Err()
+}
+
+func writeTable(file string, dir iconv.TableDir, tbl map[uint64]uint64) error {
+        var buf bytes.Buffer
+        if err := iconv.WriteTable(&buf, *charset, dir, tbl); err != nil {
+                return err
+        }
+        return os.WriteFile(file, buf.Bytes(), 0644)
+}
@@ -0,0 +1,310 @@
+// goiconv 是基于本库的编码转换工具, 用法与iconv相同:
+//
+//	goiconv -f GBK -t UTF-8 [-c] [-o 输出文件] [文件...]
+//	goiconv -f GBK -t UTF-8 -i [-b .bak] [-r] 文件或目录...
+//	goiconv -l
+//
+// 没有文件参数时从标准输入读取. 出现非法输入, 无法转换的字符(含-c跳过的字符)
+// 或输入末尾字符不完整时退出码为1, 参数错误时为64, 与GNU iconv一致.
+// 映射表目录由-tables或环境变量GOICONV_TABLES指定, 默认为程序所在目录(其中有映射表时)或源码目录.
+package main
+
+import (
+        "errors"
+        "flag"
+        "fmt"
+        "io"
+        "io/fs"
+        "os"
+        "path/filepath"
+        "strings"
+
+        iconv "github.com/hwch/iconv"
+)
+
+const bufSize = 32 * 1024
+
+var (
+        fromCode  string
+        toCode    string
+        omit      bool
+        list      bool
+        output    string
+        inPlace   bool
+        backup    string
+        recursive bool
+        tableDir  string
+)
+
+func init() {
+        flag.StringVar(&fromCode, "f", "UTF-8", "源编码")
+        flag.StringVar(&fromCode, "from-code", "UTF-8", "同-f")
+        flag.StringVar(&toCode, "t", "UTF-8", "目标编码")
+        flag.StringVar(&toCode, "to-code", "UTF-8", "同-t")
+        flag.BoolVar(&omit, "c", false, "跳过无效或无法转换的字符")
+        flag.BoolVar(&list, "l", false, "列出支持的编码")
+        flag.BoolVar(&list, "list", false, "同-l")
+        flag.StringVar(&output, "o", "", "输出文件, 默认为标准输出")
+        flag.BoolVar(&inPlace, "i", false, "就地转换文件")
+        flag.StringVar(&backup, "b", ".bak", "就地转换时原文件的备份后缀, 为空时不备份")
+        flag.BoolVar(&recursive, "r", false, "就地转换时递归处理目录")
+        flag.StringVar(&tableDir, "tables", os.Getenv("GOICONV_TABLES"), "映射表目录")
+}
+
+func main() {
+        flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+        if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+                if err == flag.ErrHelp {
+                        os.Exit(0)
+                }
+                os.Exit(64)
+        }
+        if list {
+                for _, name := range iconv.Charsets() {
+                        fmt.Println(name)
+                }
+                return
+        }
+        if inPlace && output != "" {
+                fmt.Fprintln(os.Stderr, "goiconv: -i与-o不能同时使用")
+                os.Exit(64)
+        }
+        if recursive && !inPlace {
+                fmt.Fprintln(os.Stderr, "goiconv: -r只能与-i同时使用")
+                os.Exit(64)
+        }
+        if inPlace && flag.NArg() == 0 {
+                fmt.Fprintln(os.Stderr, "goiconv: -i需要指定文件")
+                os.Exit(64)
+        }
+        setTableDir()
+        probe, err := newCoder(nil)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "goiconv: 不支持从%s到%s的转换: %s\n", fromCode, toCode, strings.TrimSpace(err.Error()))
+                os.Exit(1)
+        }
+        // 转换期间保持对映射表的引用, 各文件的转换器共用已加载的映射表
+        status := run()
+        probe.Close()
+        os.Exit(status)
+}
+
+// 按命令行参数转换各文件, 返回退出码
+func run() int {
+        status := 0
+        if inPlace {
+                for _, name := range flag.Args() {
+                        if !convertPath(name) {
+                                status = 1
+                        }
+                }
+                return status
+        }
+
+        var w io.Writer = os.Stdout
+        if output != "" {
+                f, err := os.Create(output)
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                        return 1
+                }
+                w = f
+        }
+        if flag.NArg() == 0 {
+                if err := convert(os.Stdin, w); err != nil {
+                        report("(标准输入)", err)
+                        status = 1
+                }
+        }
+        for _, name := range flag.Args() {
+                if !convertFile(name, w) {
+                        status = 1
+                }
+        }
+        if f, ok := w.(*os.File); ok && f != os.Stdout {
+                if err := f.Close(); err != nil {
+                        fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                        status = 1
+                }
+        }
+        return status
+}
+
+// 程序所在目录中有映射表时使用该目录, 以便复制到没有源码的环境中运行
+func setTableDir() {
+        if tableDir == "" {
+                if exe, err := os.Executable(); err == nil {
+                        dir := filepath.Dir(exe)
+                        if _, err := os.Stat(filepath.Join(dir, "Gbk2Unicode.db")); err == nil {
+                                tableDir = dir
+                        }
+                }
+        }
+        if tableDir != "" {
+                iconv.SetTableDir(tableDir)
+        }
+}
+
+// -c跳过字符时转换仍然完成, 但退出码为1
+var errOmitted = errors.New("跳过了无效或无法转换的字符")
+
+func newCoder(omitted *bool) (iconv.Converter, error) {
+        opts := []iconv.Option{iconv.WithStream()}
+        if omit {
+                opts = append(opts, iconv.WithOmitInvalid(func(off int, err error) {
+                        if omitted != nil {
+                                *omitted = true
+                        }
+                }))
+        }
+        return iconv.NewCoderByName(fromCode, toCode, opts...)
+}
+
+// 分块转换r中的全部数据并写入w
+func convert(r io.Reader, w io.Writer) error {
+        omitted := false
+        cv, err := newCoder(&omitted)
+        if err != nil {
+                return err
+        }
+        defer cv.Close()
+        in := make([]byte, bufSize)
+        out := make([]byte, cv.MaxEncodedLen(bufSize))
+        pos := 0
+        for {
+                n, rerr := r.Read(in)
+                if n > 0 {
+                        m, err := cv.Convert(in[:n], out)
+                        var ce *iconv.ConvertError
+                        if errors.As(err, &ce) {
+                                // 同GNU iconv, 先输出出错位置之前的转换结果
+                                if _, werr := w.Write(out[:ce.Written]); werr != nil {
+                                        return werr
+                                }
+                                return fmt.Errorf("第%d字节处转换失败: %v", pos+ce.Offset, ce.Err)
+                        }
+                        if err != nil {
+                                return fmt.Errorf("第%d字节起的数据转换失败: %v", pos, err)
+                        }
+                        if _, err := w.Write(out[:m]); err != nil {
+                                return err
+                        }
+                        pos += n
+                }
+                if rerr == io.EOF {
+                        break
+                }
+                if rerr != nil {
+                        return rerr
+                }
+        }
+        if err := cv.Flush(); err != nil {
+                return err
+        }
+        if omitted {
+                return errOmitted
+        }
+        return nil
+}
+
+func report(name string, err error) {
+        fmt.Fprintf(os.Stderr, "goiconv: %s: %v\n", name, err)
+}
+
+func convertFile(name string, w io.Writer) bool {
+        f, err := os.Open(name)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                return false
+        }
+        defer f.Close()
+        if err := convert(f, w); err != nil {
+                report(name, err)
+                return false
+        }
+        return true
+}
+
+func convertPath(name string) bool {
+        fi, err := os.Stat(name)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                return false
+        }
+        if !fi.IsDir() {
+                return convertInPlace(name, fi.Mode())
+        }
+        if !recursive {
+                fmt.Fprintf(os.Stderr, "goiconv: %s: 是目录(使用-r递归转换)\n", name)
+                return false
+        }
+        ok := true
+        err = filepath.WalkDir(name, func(p string, d fs.DirEntry, err error) error {
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                        ok = false
+                        return nil
+                }
+                if !d.Type().IsRegular() {
+                        return nil
+                }
+                // 跳过之前转换留下的备份文件
+                if backup != "" && strings.HasSuffix(p, backup) {
+                        return nil
+                }
+                info, err := d.Info()
+                if err != nil {
+                        fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                        ok = false
+                        return nil
+                }
+                if !convertInPlace(p, info.Mode()) {
+                        ok = false
+                }
+                return nil
+        })
+        return ok && err == nil
+}
+
+// 转换结果先写入同目录下的临时文件, 全部成功后才替换原文件, 失败时原文件不变.
+// -c跳过字符时仍替换原文件
+func convertInPlace(name string, mode fs.FileMode) bool {
+        in, err := os.Open(name)
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                return false
+        }
+        defer in.Close()
+        tmp, err := os.CreateTemp(filepath.Dir(name), "."+filepath.Base(name)+".goiconv*")
+        if err != nil {
+                fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                return false
+        }
+        cerr := convert(in, tmp)
+        if cerr != nil && cerr != errOmitted {
+                tmp.Close()
+                os.Remove(tmp.Name())
+                report(name, cerr)
+                return false
+        }
+        err = tmp.Chmod(mode.Perm())
+        if cerr := tmp.Close(); err == nil {
+                err = cerr
+        }
+        if err == nil && backup != "" {
+                err = os.Rename(name, name+backup)
+        }
+        if err == nil {
+                err = os.Rename(tmp.Name(), name)
+        }
+        if err != nil {
+                os.Remove(tmp.Name())
+                fmt.Fprintf(os.Stderr, "goiconv: %v\n", err)
+                return false
+        }
+        if cerr != nil {
+                report(name, cerr)
+                return false
+        }
+        return true
+}
@@ -0,0 +1,283 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+        "unicode/utf8"
+)
+
+// 未知编码的识别: 先检查BOM及零字节分布, 再用各编码的字节结构(scanUTF8, ValidGB18030)
+// 排除不可能的编码, 最后按常用汉字的出现比例在GBK/GB18030, Big5及UTF-16之间打分.
+
+// 常用汉字(简体及繁体各约五百字), 用于按字频打分
+var g_CommonHanzi = hanziSet("的一是不了人我在有他这中大来上国个到说们为子和你地出道也时年得就那要下以生会自着去之过家学对可她里后小么心多天而能好都然没日于起还发成事只作当想看文无开手十用主行方又如前所本见经头面公同三已老从动两长知民样现分将外但身些与高意进把法此实回二理美点月明其种声全工己话儿者向情部正名定女问力机给等几很业最间新什打便位因重被走电四第门相次东政海口使教西再平真听世气信北少关并内加化由却代军产入先山五太水万市眼体别处总才场师书比住员九笑性通目华报立马命张活难神数件安表原车白应路期叫死常提感金何更反合放做系计或司利受光王果亲界及今京务制解各任至清物台象记边共风战干接它许八特觉望直服毛林题建南度统色字请交爱让认算论百吃义科怎元社术结六功指思非流每青管夫连远资队跟带花快条院变联言权往展该领传近留红治决周保达办运武半候七必城父强步完革深区即求品士转量空甚众技轻程告江语英基派满式李息写呢识极令黄德收脸钱党倒未持取设始版双历越史商千片容研像找友孩站广改议形委早房音火际则首单据导影失拿网香似斯专石若兵弟谁校读志飞观争究包组造落视济喜离虽坐集编宝谈府拉黑且随格尽剑讲布杀微怕母调局根曾准团段终乐切级克精哪官示冷域" +
+        "這來國個說們為時會著過學對裡後麼沒於還發當開無見經頭從動兩長樣現將與進點種聲話兒問機給幾業間電門東聽氣關內卻軍產萬體別處總場師書員華報馬張難數車應親務記邊風戰許覺統請愛讓認論義術結條變聯權該領傳紅決達辦運強區轉眾輕語滿寫識極黃臉錢黨設雙歷廣議際單據導網專誰讀飛觀爭組視濟離雖編寶談隨盡劍講殺調團終樂級臺灣這裏們醫歲帶習區園圖圓壓錄島幫廳歡歸態慶應戲戶擊擔據擴損搖數斷於晉時書會條來東極樣橋檢標機權歐歲歷殘氣漢潔濃灣無爭爺牆獨獎環現產畫當療發盤眾碼確禮種稱穩競筆範築簡糧約級紀純紙細終組結給統絲經綠網線練縣總績繼續羅義習聖聞聯職肅腦興舉舊艦藝萬葉蘇處號術衛補裝見規視親覺觀記設許試詩話詳誌認語誤說課調談請論議護讀變讓豐貝負財貨責貴買貿費資賽趕趙車軍輪輸農這運過達遠適選還邊郵鄉醫釋針鐘鐵銀錢錯鎮長門開間閱關陸陽隊階際險隨難電靜頁頂項順須預領頭題顏願類顯風飛飯館驗體髮鬥魚鳥麥黃點黨齊龍")
+
+func hanziSet(s string) map[rune]bool {
+        set := make(map[rune]bool, len(s)/3)
+        for _, r := range s {
+                set[r] = true
+        }
+        return set
+}
+
+// 用给定编码逐字符解码, 返回非ASCII字符数及其中常用汉字数; 无法解码时ok为false.
+// 末尾不完整的字符(取样截断造成)不视为错误.
+func hanziStat(name string, from []byte) (wide, common int, ok bool) {
+        cs := g_Charsets[name]
+        st := new(codeState)
+        tbl, err := cs.loadDec()
+        if err != nil {
+                return 0, 0, false
+        }
+        defer releaseTables(tableFiles(cs.decFile))
+        st.decTbl = tbl
+        for i := 0; i < len(from); {
+                code, n, err := cs.decode(st, from[i:])
+                if err == errShortSrc {
+                        break
+                }
+                if err != nil {
+                        return 0, 0, false
+                }
+                if code >= 0x80 {
+                        wide++
+                        if g_CommonHanzi[rune(code)] {
+                                common++
+                        }
+                }
+                i += n
+        }
+        return wide, common, true
+}
+
+// 识别未知编码的字符串, 返回编码名称(UTF-8, GBK, GB18030, BIG5, UTF-16LE或UTF-16BE,
+// 可直接用于NewCoderByName)及0~1之间的置信度. 纯ASCII视为UTF-8, 空输入返回"".
+// 识别所用的GBK, BIG5等映射表用完即释放, 没有转换器引用时每次调用都要重新加载;
+// 频繁识别时可保持打开相应的转换器(如NewCoder(GBK_UTF8_IDX)).
+func Detect(from []byte) (charset string, confidence float64) {
+        if len(from) == 0 {
+                return "", 0
+        }
+        switch {
+        case len(from) >= 3 && from[0] == 0xef && from[1] == 0xbb && from[2] == 0xbf:
+                return "UTF-8", 1
+        case len(from) >= 2 && from[0] == 0xff && from[1] == 0xfe:
+                return "UTF-16LE", 1
+        case len(from) >= 2 && from[0] == 0xfe && from[1] == 0xff:
+                return "UTF-16BE", 1
+        }
+        sample := from
+        if len(sample) > 64*1024 {
+                sample = sample[:64*1024]
+        }
+        zero := 0
+        ascii := true
+        for _, b := range sample {
+                if b == 0 {
+                        zero++
+                }
+                if b >= 0x80 {
+                        ascii = false
+                }
+        }
+        if zero*5 >= len(sample) && len(from)%2 == 0 {
+                idx, _ := DetectUTF16(sample)
+                if idx == UTF16_BE_UTF8_IDX {
+                        return "UTF-16BE", 0.5 + 0.5*float64(zero*2)/float64(len(sample))
+                }
+                return "UTF-16LE", 0.5 + 0.5*float64(zero*2)/float64(len(sample))
+        }
+        if ascii && zero == 0 {
+                return "UTF-8", 1
+        }
+        if n, err := scanUTF8(sample); err == nil && (n == len(sample) || len(sample) < len(from)) {
+                end := len(sample)
+                if len(sample) < len(from) {
+                        for end > 0 && end > len(sample)-6 && sample[end-1]&0xc0 == 0x80 {
+                                end--
+                        }
+                        if end > 0 && sample[end-1] >= 0xc0 {
+                                end--
+                        }
+                }
+                if utf8.Valid(sample[:end]) {
+                        return "UTF-8", 0.99
+                }
+        }
+
+        charset = ""
+        best := -1.0
+        score := func(name string, wide, common int) {
+                if wide == 0 {
+                        return
+                }
+                if s := float64(common) / float64(wide); s > best {
+                        charset, best = name, s
+                }
+        }
+        if off := ValidGB18030(sample); off < 0 || (len(sample) < len(from) && off >= len(sample)-3) {
+                if wide, common, ok := hanziStat("GBK", sample); ok {
+                        name := "GBK"
+                        for i := 0; i+1 < len(sample); {
+                                if sample[i] < 0x80 {
+                                        i++
+                                } else if sample[i+1] >= 0x30 && sample[i+1] <= 0x39 {
+                                        name = "GB18030"
+                                        break
+                                } else {
+                                        i += 2
+                                }
+                        }
+                        score(name, wide, common)
+                }
+        }
+        if wide, common, ok := hanziStat("BIG5", sample); ok {
+                score("BIG5", wide, common)
+        }
+        if len(from)%2 == 0 {
+                for _, name := range []string{"UTF-16LE", "UTF-16BE"} {
+                        if wide, common, ok := hanziStat(name, sample); ok {
+                                // UTF-16的每个码元都参与打分, 需更高的比例才能胜出
+                                score(name, wide*2, common)
+                        }
+                }
+        }
+        if best < 0 {
+                return "", 0
+        }
+        return charset, 0.3 + 0.69*best
+}
+
+// Detect可能识别出的编码
+var g_DetectCharsets = []string{"UTF-8", "GBK", "GB18030", "BIG5", "UTF-16LE", "UTF-16BE"}
+
+// 创建自动识别源编码的转换器: 第一次转换时用Detect识别输入的编码,
+// 之后的分块转换沿用该编码, 调用Flush后重新识别.
+func NewCoderAuto(target string) (Converter, error) {
+        target = charsetName(target)
+        if d, ok := g_Charsets[target]; !ok || d.encode == nil {
+                return nil, fmt.Errorf("Error: 未知编码格式[%s]\n", target)
+        }
+        return &autoCoder{target: target}, nil
+}
+
+type autoCoder struct {
+        target string
+        inner  Converter // 识别出源编码后创建
+        closed bool
+}
+
+func (c *autoCoder) Convert(in, out []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        if c.inner == nil {
+                if len(in) == 0 {
+                        return 0, nil
+                }
+                name, _ := Detect(in)
+                if name == "" {
+                        return 0, errors.New("无法识别输入的编码")
+                }
+                inner, err := NewCoderByName(name, c.target)
+                if err != nil {
+                        return 0, err
+                }
+                c.inner = inner
+        }
+        return c.inner.Convert(in, out)
+}
+
+// 识别出源编码之前为"AUTO"
+func (c *autoCoder) Source() string {
+        if c.inner == nil {
+                return "AUTO"
+        }
+        return c.inner.Source()
+}
+
+func (c *autoCoder) Target() string { return c.target }
+
+// 识别出源编码之前按可识别的各编码中最大者计算
+func (c *autoCoder) MaxOutputPerInputByte() int {
+        if c.inner != nil {
+                return c.inner.MaxOutputPerInputByte()
+        }
+        max := 0
+        for _, name := range g_DetectCharsets {
+                inner, err := NewCoderByName(name, c.target)
+                if err != nil {
+                        continue
+                }
+                if n := inner.MaxOutputPerInputByte(); n > max {
+                        max = n
+                }
+                inner.Close()
+        }
+        return max
+}
+
+func (c *autoCoder) MaxEncodedLen(n int) int {
+        if c.inner != nil {
+                return c.inner.MaxEncodedLen(n)
+        }
+        max := 0
+        for _, name := range g_DetectCharsets {
+                inner, err := NewCoderByName(name, c.target)
+                if err != nil {
+                        continue
+                }
+                if m := inner.MaxEncodedLen(n); m > max {
+                        max = m
+                }
+                inner.Close()
+        }
+        return max
+}
+
+// 尚未识别出源编码时按src识别, 不保留识别结果
+func (c *autoCoder) EncodedLen(src []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        if c.inner != nil {
+                return c.inner.EncodedLen(src)
+        }
+        if len(src) == 0 {
+                return 0, nil
+        }
+        name, _ := Detect(src)
+        if name == "" {
+                return 0, errors.New("无法识别输入的编码")
+        }
+        inner, err := NewCoderByName(name, c.target)
+        if err != nil {
+                return 0, err
+        }
+        defer inner.Close()
+        return inner.EncodedLen(src)
+}
+
+func (c *autoCoder) Flush() error {
+        if c.inner == nil {
+                return nil
+        }
+        err := c.inner.Flush()
+        c.inner.Close()
+        c.inner = nil
+        return err
+}
+
+func (c *autoCoder) Reset() {
+        if c.inner != nil {
+                c.inner.Close()
+                c.inner = nil
+        }
+}
+
+func (c *autoCoder) Close() error {
+        c.Reset()
+        c.closed = true
+        return nil
+}
@@ -0,0 +1,34 @@
+// Package better 在UTF-8, UTF-16, GBK/GB2312/GB18030及其它常用编码之间转换.
+//
+// NewCoder按CODING_IDX创建转换器, NewCoderByName按编码名称创建(名称不区分大小写,
+// 可使用别名), Charsets返回所有支持的编码名称. 两种编码之间有专门的转换函数(如GBK与UTF-8)
+// 时直接使用该函数, 否则源编码逐字符解码为Unicode码点后再由目标编码编码.
+//
+// 支持的编码及使用的映射表:
+//
+//   - GBK, GB2312, GB18030: Gbk2Unicode.db/Unicode2Gbk.db; HZ-GB-2312与ISO-2022-CN
+//     使用其中GB2312的部分, 解码时行末复位到ASCII
+//   - BIG5: Big52Unicode.db/Unicode2Big5.db; BIG5-HKSCS(含HKSCS-2008扩展及组合字符序列):
+//     Big5hkscs2Unicode.db/Unicode2Big5hkscs.db
+//   - SHIFT_JIS, CP932(含用户定义区), EUC-JP(含JIS X 0212), 均含NEC/IBM扩展字符: Sjis2Unicode.db,
+//     Cp9322Unicode.db, Eucjp2Unicode.db及对应的Unicode2*.db; ISO-2022-JP使用EUC-JP的映射表
+//   - CP949(UHC): Cp9492Unicode.db/Unicode2Cp949.db; EUC-KR与ISO-2022-KR仅使用其中
+//     KS X 1001的部分
+//   - IBM-935, IBM-1388(EBCDIC中文, 单字节与SO/SI之间的双字节在同一映射表中):
+//     Ibm9352Unicode.db, Ibm13882Unicode.db及对应的Unicode2*.db
+//   - 单字节编码(ISO-8859-1~16, WINDOWS-1250~1258, KOI8-R/U, CP437/850/866, EBCDIC的
+//     CP037/500/1047): sbcs目录下的编码到Unicode映射表, 反向映射在加载时生成
+//   - UTF-8, UTF-16LE/BE, UTF-32LE/BE, UCS-2LE/BE不使用映射表; UTF-16及UTF-32解码时按BOM
+//     或零字节分布识别字节序, 编码时输出BOM及小端序
+//
+// 映射表文件的格式见table.go, 生成方法见gen.go. 映射表默认从本源文件所在目录加载,
+// 脱离源码目录运行的程序用SetTableDir指定目录.
+//
+// 默认每次调用Convert的输入是独立的数据: 源编码的BOM被跳过, 目标编码的BOM写在输出开头,
+// 有移位状态的源编码及目标编码从初始状态开始解码及编码(ISO-2022-KR等重新输出指定序列),
+// 输出末尾复位到ASCII, 输入末尾不完整的字符是错误. WithStream使多次调用视为同一数据流:
+// BOM及指定序列只在流的开头输出, 输入末尾不完整的字符及解码的移位状态保留到下次调用,
+// 全部输入转换完后调用Flush.
+// 其它可选设置见WithOmitInvalid, WithLossReport, WithOverrides, WithPUAPolicy,
+// WithNormalization, WithWidthFold及WithChineseConversion.
+package better
@@ -0,0 +1,90 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+)
+
+// EBCDIC编码: 单字节的CP037/CP500/CP1047(映射表在sbcs目录), 以及有移位状态的
+// IBM-935(简体中文, 对应GB2312)和IBM-1388(对应GBK). 后两者以SO(0x0E)进入双字节
+// 状态, SI(0x0F)回到单字节状态, 映射表中单字节的键小于0x100, 双字节的键为0x4040~0xFEFE.
+
+const (
+        ebcdicSO = 0x0e
+        ebcdicSI = 0x0f
+)
+
+// IBM-935/IBM-1388的移位状态
+const (
+        ebcdicSBCS = iota
+        ebcdicDBCS
+)
+
+func decodeEBCDICDBCS(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        switch {
+        case b == ebcdicSO:
+                st.decMode = ebcdicDBCS
+                return noCode, 1, nil
+        case b == ebcdicSI:
+                st.decMode = ebcdicSBCS
+                return noCode, 1, nil
+        case st.decMode == ebcdicSBCS:
+                if v, ok := st.decTbl[uint64(b)]; ok {
+                        return v, 1, nil
+                }
+                return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", b)
+        }
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        if b < 0x40 || b == 0xff || from[1] < 0x40 || from[1] == 0xff {
+                return 0, 0, errors.New("非法EBCDIC双字节编码")
+        }
+        tmpDbcs := uint64(b)<<8 | uint64(from[1])
+        if v, ok := st.decTbl[tmpDbcs]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpDbcs)
+}
+
+func encodeEBCDICDBCS(st *codeState, code uint64, to []byte) (int, error) {
+        v, ok := st.encTbl[code]
+        if !ok {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        j := 0
+        mode := ebcdicSBCS
+        if v >= 0x100 {
+                mode = ebcdicDBCS
+        }
+        if st.encMode != mode {
+                if len(to) < 1 {
+                        return 0, errShortBuf
+                }
+                to[0] = ebcdicSI
+                if mode == ebcdicDBCS {
+                        to[0] = ebcdicSO
+                }
+                j++
+                st.encMode = mode
+        }
+        m, err := putMBCS(v, to[j:])
+        if err != nil {
+                return 0, err
+        }
+        return j + m, nil
+}
+
+// 输出结束时以SI回到单字节状态
+func resetEBCDICDBCS(st *codeState, to []byte) (int, error) {
+        if st.encMode == ebcdicSBCS {
+                return 0, nil
+        }
+        if len(to) < 1 {
+                return 0, errShortBuf
+        }
+        to[0] = ebcdicSI
+        st.encMode = ebcdicSBCS
+        return 1, nil
+}
@@ -0,0 +1,20 @@
+package better
+
+import "fmt"
+
+// 转换失败时返回的错误. 转换函数出错时返回的字节数仍为0, 但输出缓冲区的前Written字节
+// 已是输入前Offset字节的转换结果, 需要时可保留(如iconv命令输出出错位置之前的内容).
+// 有移位状态的目标编码(如ISO-2022-JP), 这部分输出末尾可能没有复位到ASCII的转义序列.
+type ConvertError struct {
+        Offset  int   // 出错的字符在本次调用输入中的偏移, 跨调用拼接的不完整字符可能为负
+        Written int   // 出错前已写入输出缓冲区的字节数
+        Err     error // 出错原因
+}
+
+func (e *ConvertError) Error() string {
+        return fmt.Sprintf("偏移%d: %v", e.Offset, e.Err)
+}
+
+func (e *ConvertError) Unwrap() error {
+        return e.Err
+}
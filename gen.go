@@ -0,0 +1,57 @@
+package better
+
+// 映射表由mapping目录下的上游映射数据生成, 修改映射数据后执行go generate重新生成.
+// 各文件的来源及许可见mapping/README.md: unicode及rfc目录为Unicode联盟TXT格式, whatwg目录
+// 为WHATWG index格式(按-pointer或文件名后的"=方案"把指针换算为编码), icu及glibc目录为
+// ICU UCM格式(区分双向, 只用于编码及只用于解码的映射).
+//
+// 本库没有go.mod, cmd下的命令以github.com/hwch/iconv导入本库, 需将源码放在
+// $GOPATH/src/github.com/hwch/iconv并以GOPATH模式执行:
+//
+//	GO111MODULE=off go generate
+//
+// 正规化数据normdata.go由cmd/gennorm生成, 需先下载对应版本的Unicode字符数据库:
+//
+//	go run ./cmd/gennorm -version 14.0.0 -o normdata.go UnicodeData.txt CompositionExclusions.txt
+
+//go:generate go run ./cmd/gentables -name GBK -dec Gbk2Unicode.db -enc Unicode2Gbk.db mapping/whatwg/index-gb18030-ranges.txt=gb18030-ranges mapping/icu/gb-18030-2000.ucm
+//go:generate go run ./cmd/gentables -name BIG5 -ascii -pointer big5-a1 -dec Big52Unicode.db -enc Unicode2Big5.db mapping/whatwg/index-big5.txt
+//go:generate go run ./cmd/gentables -name BIG5-HKSCS -ascii -pointer big5 -dec Big5hkscs2Unicode.db -enc Unicode2Big5hkscs.db mapping/whatwg/index-big5.txt mapping/whatwg/big5-hkscs-combining.txt
+//go:generate go run ./cmd/gentables -name SHIFT_JIS -ascii -pointer shift_jis -dec Sjis2Unicode.db -enc Unicode2Sjis.db mapping/whatwg/index-jis0208.txt mapping/unicode/JIS0201.TXT=jis0201-kana
+//go:generate go run ./cmd/gentables -name CP932 -ascii -pointer shift_jis -dec Cp9322Unicode.db -enc Unicode2Cp932.db mapping/whatwg/index-jis0208.txt mapping/unicode/JIS0201.TXT=jis0201-kana mapping/whatwg/shift_jis-eudc.txt
+//go:generate go run ./cmd/gentables -name EUC-JP -ascii -dec Eucjp2Unicode.db -enc Unicode2Eucjp.db mapping/whatwg/index-jis0208.txt=euc-jp mapping/whatwg/index-jis0212.txt=jis0212 mapping/unicode/JIS0201.TXT=jis0201-kana-euc
+//go:generate go run ./cmd/gentables -name CP949 -ascii -pointer euc-kr -dec Cp9492Unicode.db -enc Unicode2Cp949.db mapping/whatwg/index-euc-kr.txt
+//go:generate go run ./cmd/gentables -name IBM-935 -dec Ibm9352Unicode.db -enc Unicode2Ibm935.db mapping/glibc/IBM935.ucm
+//go:generate go run ./cmd/gentables -name IBM-1388 -dec Ibm13882Unicode.db -enc Unicode2Ibm1388.db mapping/glibc/IBM1388.ucm
+//go:generate go run ./cmd/gentables -name CP037 -dec sbcs/CP037.db mapping/unicode/CP037.TXT
+//go:generate go run ./cmd/gentables -name CP1047 -dec sbcs/CP1047.db mapping/icu/glibc-IBM1047-2.1.2.ucm
+//go:generate go run ./cmd/gentables -name CP437 -dec sbcs/CP437.db mapping/unicode/CP437.TXT
+//go:generate go run ./cmd/gentables -name CP500 -dec sbcs/CP500.db mapping/unicode/CP500.TXT
+//go:generate go run ./cmd/gentables -name CP850 -dec sbcs/CP850.db mapping/unicode/CP850.TXT
+//go:generate go run ./cmd/gentables -name CP866 -dec sbcs/CP866.db mapping/unicode/CP866.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-1 -dec sbcs/ISO-8859-1.db mapping/unicode/8859-1.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-10 -dec sbcs/ISO-8859-10.db mapping/unicode/8859-10.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-11 -dec sbcs/ISO-8859-11.db mapping/unicode/8859-11.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-13 -dec sbcs/ISO-8859-13.db mapping/unicode/8859-13.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-14 -dec sbcs/ISO-8859-14.db mapping/unicode/8859-14.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-15 -dec sbcs/ISO-8859-15.db mapping/unicode/8859-15.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-16 -dec sbcs/ISO-8859-16.db mapping/unicode/8859-16.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-2 -dec sbcs/ISO-8859-2.db mapping/unicode/8859-2.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-3 -dec sbcs/ISO-8859-3.db mapping/unicode/8859-3.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-4 -dec sbcs/ISO-8859-4.db mapping/unicode/8859-4.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-5 -dec sbcs/ISO-8859-5.db mapping/unicode/8859-5.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-6 -dec sbcs/ISO-8859-6.db mapping/unicode/8859-6.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-7 -dec sbcs/ISO-8859-7.db mapping/unicode/8859-7.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-8 -dec sbcs/ISO-8859-8.db mapping/unicode/8859-8.TXT
+//go:generate go run ./cmd/gentables -name ISO-8859-9 -dec sbcs/ISO-8859-9.db mapping/unicode/8859-9.TXT
+//go:generate go run ./cmd/gentables -name KOI8-R -dec sbcs/KOI8-R.db mapping/unicode/KOI8-R.TXT
+//go:generate go run ./cmd/gentables -name KOI8-U -dec sbcs/KOI8-U.db mapping/rfc/KOI8-U.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1250 -dec sbcs/WINDOWS-1250.db mapping/unicode/CP1250.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1251 -dec sbcs/WINDOWS-1251.db mapping/unicode/CP1251.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1252 -dec sbcs/WINDOWS-1252.db mapping/unicode/CP1252.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1253 -dec sbcs/WINDOWS-1253.db mapping/unicode/CP1253.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1254 -dec sbcs/WINDOWS-1254.db mapping/unicode/CP1254.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1255 -dec sbcs/WINDOWS-1255.db mapping/unicode/CP1255.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1256 -dec sbcs/WINDOWS-1256.db mapping/unicode/CP1256.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1257 -dec sbcs/WINDOWS-1257.db mapping/unicode/CP1257.TXT
+//go:generate go run ./cmd/gentables -name WINDOWS-1258 -dec sbcs/WINDOWS-1258.db mapping/unicode/CP1258.TXT
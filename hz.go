@@ -0,0 +1,235 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+)
+
+// HZ-GB-2312(RFC 1843)与ISO-2022-CN(RFC 1922)是GB2312的7位封装, 使用GBK的映射表,
+// 仅接受其中GB2312的部分(首字节0xA1~0xA9或0xB0~0xF7, 次字节0xA1~0xFE).
+// ISO-2022-CN只支持GB2312, 不支持CNS 11643的各平面.
+
+// HZ及ISO-2022-CN的移位状态
+const (
+        cnASCII      = iota
+        cnDesignated // 已由ESC $ ) A指定GB2312但处于SI状态, 仅用于ISO-2022-CN
+        cnGB2312
+)
+
+func isGB2312(v uint64) bool {
+        lead := v >> 8
+        trail := v & 0xff
+        return ((lead >= 0xa1 && lead <= 0xa9) || (lead >= 0xb0 && lead <= 0xf7)) && trail >= 0xa1 && trail <= 0xfe
+}
+
+// 解码GB模式下的一个双字节字符, 两字节均为0x21~0x7E
+func decodeGB2312Pair(st *codeState, from []byte) (uint64, int, error) {
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        if from[1] < 0x21 || from[1] > 0x7e {
+                return 0, 0, errors.New("非法GB2312编码")
+        }
+        tmpGb := uint64(from[0]|0x80)<<8 | uint64(from[1]|0x80)
+        if !isGB2312(tmpGb) {
+                return 0, 0, fmt.Errorf("非法GB2312编码[0x%x]", tmpGb)
+        }
+        if v, ok := st.decTbl[tmpGb]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpGb)
+}
+
+func lookupGB2312(st *codeState, code uint64) (uint64, error) {
+        v, ok := st.encTbl[code]
+        if !ok || !isGB2312(v) {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        return v & 0x7f7f, nil
+}
+
+// HZ: "~{"进入GB模式, "~}"回到ASCII, "~~"表示'~', "~"加换行为续行; 行末回到ASCII
+func decodeHZ(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        if b >= 0x80 {
+                return 0, 0, errors.New("非法HZ编码")
+        }
+        if b == '~' {
+                if len(from) < 2 {
+                        return 0, 0, errShortSrc
+                }
+                switch from[1] {
+                case '{':
+                        st.decMode = cnGB2312
+                        return noCode, 2, nil
+                case '}':
+                        st.decMode = cnASCII
+                        return noCode, 2, nil
+                case '\n':
+                        return noCode, 2, nil
+                case '~':
+                        if st.decMode == cnASCII {
+                                return '~', 2, nil
+                        }
+                }
+                if st.decMode == cnASCII {
+                        return 0, 0, fmt.Errorf("非法HZ转义序列[%q]", from[:2])
+                }
+        }
+        if b == '\n' || b == '\r' {
+                st.decMode = cnASCII
+        }
+        if st.decMode == cnASCII || b < 0x21 || b > 0x7e {
+                return uint64(b), 1, nil
+        }
+        return decodeGB2312Pair(st, from)
+}
+
+func encodeHZ(st *codeState, code uint64, to []byte) (int, error) {
+        j := 0
+        if code < 0x80 {
+                if st.encMode == cnGB2312 {
+                        if len(to) < 2 {
+                                return 0, errShortBuf
+                        }
+                        j += copy(to, "~}")
+                        st.encMode = cnASCII
+                }
+                if code == '~' {
+                        if len(to) < j+2 {
+                                return 0, errShortBuf
+                        }
+                        return j + copy(to[j:], "~~"), nil
+                }
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = byte(code)
+                return j + 1, nil
+        }
+        v, err := lookupGB2312(st, code)
+        if err != nil {
+                return 0, err
+        }
+        if st.encMode != cnGB2312 {
+                if len(to) < 2 {
+                        return 0, errShortBuf
+                }
+                j += copy(to, "~{")
+                st.encMode = cnGB2312
+        }
+        if len(to) < j+2 {
+                return 0, errShortBuf
+        }
+        to[j] = byte(v >> 8)
+        to[j+1] = byte(v)
+        return j + 2, nil
+}
+
+func resetHZ(st *codeState, to []byte) (int, error) {
+        if st.encMode != cnGB2312 {
+                return 0, nil
+        }
+        if len(to) < 2 {
+                return 0, errShortBuf
+        }
+        st.encMode = cnASCII
+        return copy(to, "~}"), nil
+}
+
+// ISO-2022-CN: ESC $ ) A指定GB2312, SO/SI切换; 行末指定与移位状态均复位
+func decodeISO2022CN(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        switch {
+        case b == 0x1b:
+                if len(from) < 4 {
+                        return 0, 0, errShortSrc
+                }
+                if string(from[1:4]) != "$)A" {
+                        return 0, 0, fmt.Errorf("不支持的ISO-2022-CN转义序列[%q]", from[:4])
+                }
+                if st.decMode == cnASCII {
+                        st.decMode = cnDesignated
+                }
+                return noCode, 4, nil
+        case b == 0x0e:
+                if st.decMode == cnASCII {
+                        return 0, 0, errors.New("ISO-2022-CN未指定字符集即使用SO")
+                }
+                st.decMode = cnGB2312
+                return noCode, 1, nil
+        case b == 0x0f:
+                if st.decMode == cnGB2312 {
+                        st.decMode = cnDesignated
+                }
+                return noCode, 1, nil
+        case b >= 0x80:
+                return 0, 0, errors.New("非法ISO-2022-CN编码")
+        case b == '\n' || b == '\r':
+                st.decMode = cnASCII
+        }
+        if st.decMode != cnGB2312 || b < 0x21 || b > 0x7e {
+                return uint64(b), 1, nil
+        }
+        return decodeGB2312Pair(st, from)
+}
+
+func encodeISO2022CN(st *codeState, code uint64, to []byte) (int, error) {
+        j := 0
+        if code < 0x80 {
+                if st.encMode == cnGB2312 {
+                        if len(to) < 1 {
+                                return 0, errShortBuf
+                        }
+                        to[0] = 0x0f
+                        j++
+                        st.encMode = cnDesignated
+                }
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = byte(code)
+                if code == '\n' || code == '\r' {
+                        st.encMode = cnASCII
+                }
+                return j + 1, nil
+        }
+        v, err := lookupGB2312(st, code)
+        if err != nil {
+                return 0, err
+        }
+        if st.encMode == cnASCII {
+                if len(to) < 4 {
+                        return 0, errShortBuf
+                }
+                j += copy(to, "\x1b$)A")
+                st.encMode = cnDesignated
+        }
+        if st.encMode == cnDesignated {
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = 0x0e
+                j++
+                st.encMode = cnGB2312
+        }
+        if len(to) < j+2 {
+                return 0, errShortBuf
+        }
+        to[j] = byte(v >> 8)
+        to[j+1] = byte(v)
+        return j + 2, nil
+}
+
+// 输出结束时以SI回到ASCII, GB2312的指定在本行内保持有效
+func resetISO2022CN(st *codeState, to []byte) (int, error) {
+        if st.encMode != cnGB2312 {
+                return 0, nil
+        }
+        if len(to) < 1 {
+                return 0, errShortBuf
+        }
+        to[0] = 0x0f
+        st.encMode = cnDesignated
+        return 1, nil
+}
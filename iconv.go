@@ -2,12 +2,12 @@ package better
 
 import (
         "encoding/binary"
-        "encoding/gob"
         "errors"
         "fmt"
         "os"
         "path"
         "runtime"
+        "sync"
         "unsafe"
 )
 
@@ -30,76 +30,358 @@ const (
         GBK_UTF8_IDX         = iota
         GBK2312_UTF8_IDX     = iota
         GBK18030_UTF8_IDX    = iota
+        UTF16_UTF8_IDX       = iota
+        UTF8_UTF32_LE_IDX    = iota
+        UTF32_LE_UTF8_IDX    = iota
+        UTF8_UTF32_BE_IDX    = iota
+        UTF32_BE_UTF8_IDX    = iota
+        UTF32_UTF8_IDX       = iota
+        GBK_UTF32_LE_IDX     = iota
+        UTF32_LE_GBK_IDX     = iota
+        GBK_UTF32_BE_IDX     = iota
+        UTF32_BE_GBK_IDX     = iota
+        UTF32_GBK_IDX        = iota
+        UTF8_UCS2_LE_IDX     = iota
+        UCS2_LE_UTF8_IDX     = iota
+        UTF8_UCS2_BE_IDX     = iota
+        UCS2_BE_UTF8_IDX     = iota
+        GBK_UCS2_LE_IDX      = iota
+        UCS2_LE_GBK_IDX      = iota
+        GBK_UCS2_BE_IDX      = iota
+        UCS2_BE_GBK_IDX      = iota
+        BIG5_UTF8_IDX        = iota
+        UTF8_BIG5_IDX        = iota
+        BIG5HKSCS_UTF8_IDX   = iota
+        UTF8_BIG5HKSCS_IDX   = iota
+        SJIS_UTF8_IDX        = iota
+        UTF8_SJIS_IDX        = iota
+        CP932_UTF8_IDX       = iota
+        UTF8_CP932_IDX       = iota
+        EUCJP_UTF8_IDX       = iota
+        UTF8_EUCJP_IDX       = iota
+        ISO2022JP_UTF8_IDX   = iota
+        UTF8_ISO2022JP_IDX   = iota
+        EUCKR_UTF8_IDX       = iota
+        UTF8_EUCKR_IDX       = iota
+        CP949_UTF8_IDX       = iota
+        UTF8_CP949_IDX       = iota
+        ISO2022KR_UTF8_IDX   = iota
+        UTF8_ISO2022KR_IDX   = iota
+        IBM935_GBK_IDX       = iota
+        GBK_IBM935_IDX       = iota
+        IBM935_UTF8_IDX      = iota
+        UTF8_IBM935_IDX      = iota
+        IBM1388_GBK_IDX      = iota
+        GBK_IBM1388_IDX      = iota
+        IBM1388_UTF8_IDX     = iota
+        UTF8_IBM1388_IDX     = iota
+        HZ_UTF8_IDX          = iota
+        UTF8_HZ_IDX          = iota
+        HZ_GBK_IDX           = iota
+        GBK_HZ_IDX           = iota
+        ISO2022CN_UTF8_IDX   = iota
+        UTF8_ISO2022CN_IDX   = iota
+        ISO2022CN_GBK_IDX    = iota
+        GBK_ISO2022CN_IDX    = iota
 )
 
 type eleMent struct {
         filename string
         fn       func(map[uint64]uint64, []byte, []byte) (int, error)
+        // 源编码和目标编码名称, fn为nil时经Unicode中转转换(见pivot.go)
+        src, dst string
 }
 
 var g_CodeMap = map[CODING_IDX]*eleMent{
-        GBK18030_UNICODE_IDX: &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE},
-        GBK2312_UNICODE_IDX:  &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE},
-        GBK_UNICODE_IDX:      &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE},
-        UNICODE_GBK_IDX:      &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK},
-        UNICODE_GBK2312_IDX:  &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK},
-        UNICODE_GBK18030_IDX: &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK},
-        GBK18030_UTF8_IDX:    &eleMent{"Gbk2Unicode.db", convertGBKToUTF8},
-        GBK2312_UTF8_IDX:     &eleMent{"Gbk2Unicode.db", convertGBKToUTF8},
-        GBK_UTF8_IDX:         &eleMent{"Gbk2Unicode.db", convertGBKToUTF8},
-        UTF8_GBK_IDX:         &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK},
-        UTF8_GBK18030_IDX:    &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK},
-        UTF8_GBK2312_IDX:     &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK},
-        UTF8_UTF16_LE_IDX:    &eleMent{"nil", convertUTF8ToUTF16LE},
-        UTF16_LE_UTF8_IDX:    &eleMent{"nil", convertUTF16LEToUTF8},
-        UTF8_UTF16_BE_IDX:    &eleMent{"nil", convertUTF8ToUTF16BE},
-        UTF16_BE_UTF8_IDX:    &eleMent{"nil", convertUTF16BEToUTF8},
+        GBK18030_UNICODE_IDX: &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE, "GB18030", "UNICODE"},
+        GBK2312_UNICODE_IDX:  &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE, "GB2312", "UNICODE"},
+        GBK_UNICODE_IDX:      &eleMent{"Gbk2Unicode.db", convertGBKToUNICODE, "GBK", "UNICODE"},
+        UNICODE_GBK_IDX:      &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK, "UNICODE", "GBK"},
+        UNICODE_GBK2312_IDX:  &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK, "UNICODE", "GB2312"},
+        UNICODE_GBK18030_IDX: &eleMent{"Unicode2Gbk.db", convertUNICODEToGBK, "UNICODE", "GB18030"},
+        GBK18030_UTF8_IDX:    &eleMent{"Gbk2Unicode.db", convertGBKToUTF8, "GB18030", "UTF-8"},
+        GBK2312_UTF8_IDX:     &eleMent{"Gbk2Unicode.db", convertGBKToUTF8, "GB2312", "UTF-8"},
+        GBK_UTF8_IDX:         &eleMent{"Gbk2Unicode.db", convertGBKToUTF8, "GBK", "UTF-8"},
+        UTF8_GBK_IDX:         &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK, "UTF-8", "GBK"},
+        UTF8_GBK18030_IDX:    &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK, "UTF-8", "GB18030"},
+        UTF8_GBK2312_IDX:     &eleMent{"Unicode2Gbk.db", convertUTF8ToGBK, "UTF-8", "GB2312"},
+        UTF8_UTF16_LE_IDX:    &eleMent{"nil", convertUTF8ToUTF16LE, "UTF-8", "UTF-16LE"},
+        UTF16_LE_UTF8_IDX:    &eleMent{"nil", convertUTF16LEToUTF8, "UTF-16LE", "UTF-8"},
+        UTF8_UTF16_BE_IDX:    &eleMent{"nil", convertUTF8ToUTF16BE, "UTF-8", "UTF-16BE"},
+        UTF16_BE_UTF8_IDX:    &eleMent{"nil", convertUTF16BEToUTF8, "UTF-16BE", "UTF-8"},
+        UTF16_UTF8_IDX:       &eleMent{"nil", nil, "UTF-16", "UTF-8"},
+        UTF8_UTF32_LE_IDX:    &eleMent{"nil", nil, "UTF-8", "UTF-32LE"},
+        UTF32_LE_UTF8_IDX:    &eleMent{"nil", nil, "UTF-32LE", "UTF-8"},
+        UTF8_UTF32_BE_IDX:    &eleMent{"nil", nil, "UTF-8", "UTF-32BE"},
+        UTF32_BE_UTF8_IDX:    &eleMent{"nil", nil, "UTF-32BE", "UTF-8"},
+        UTF32_UTF8_IDX:       &eleMent{"nil", nil, "UTF-32", "UTF-8"},
+        GBK_UTF32_LE_IDX:     &eleMent{"nil", nil, "GBK", "UTF-32LE"},
+        UTF32_LE_GBK_IDX:     &eleMent{"nil", nil, "UTF-32LE", "GBK"},
+        GBK_UTF32_BE_IDX:     &eleMent{"nil", nil, "GBK", "UTF-32BE"},
+        UTF32_BE_GBK_IDX:     &eleMent{"nil", nil, "UTF-32BE", "GBK"},
+        UTF32_GBK_IDX:        &eleMent{"nil", nil, "UTF-32", "GBK"},
+        UTF8_UCS2_LE_IDX:     &eleMent{"nil", nil, "UTF-8", "UCS-2LE"},
+        UCS2_LE_UTF8_IDX:     &eleMent{"nil", nil, "UCS-2LE", "UTF-8"},
+        UTF8_UCS2_BE_IDX:     &eleMent{"nil", nil, "UTF-8", "UCS-2BE"},
+        UCS2_BE_UTF8_IDX:     &eleMent{"nil", nil, "UCS-2BE", "UTF-8"},
+        GBK_UCS2_LE_IDX:      &eleMent{"nil", nil, "GBK", "UCS-2LE"},
+        UCS2_LE_GBK_IDX:      &eleMent{"nil", nil, "UCS-2LE", "GBK"},
+        GBK_UCS2_BE_IDX:      &eleMent{"nil", nil, "GBK", "UCS-2BE"},
+        UCS2_BE_GBK_IDX:      &eleMent{"nil", nil, "UCS-2BE", "GBK"},
+        BIG5_UTF8_IDX:        &eleMent{"nil", nil, "BIG5", "UTF-8"},
+        UTF8_BIG5_IDX:        &eleMent{"nil", nil, "UTF-8", "BIG5"},
+        BIG5HKSCS_UTF8_IDX:   &eleMent{"nil", nil, "BIG5-HKSCS", "UTF-8"},
+        UTF8_BIG5HKSCS_IDX:   &eleMent{"nil", nil, "UTF-8", "BIG5-HKSCS"},
+        SJIS_UTF8_IDX:        &eleMent{"nil", nil, "SHIFT_JIS", "UTF-8"},
+        UTF8_SJIS_IDX:        &eleMent{"nil", nil, "UTF-8", "SHIFT_JIS"},
+        CP932_UTF8_IDX:       &eleMent{"nil", nil, "CP932", "UTF-8"},
+        UTF8_CP932_IDX:       &eleMent{"nil", nil, "UTF-8", "CP932"},
+        EUCJP_UTF8_IDX:       &eleMent{"nil", nil, "EUC-JP", "UTF-8"},
+        UTF8_EUCJP_IDX:       &eleMent{"nil", nil, "UTF-8", "EUC-JP"},
+        ISO2022JP_UTF8_IDX:   &eleMent{"nil", nil, "ISO-2022-JP", "UTF-8"},
+        UTF8_ISO2022JP_IDX:   &eleMent{"nil", nil, "UTF-8", "ISO-2022-JP"},
+        EUCKR_UTF8_IDX:       &eleMent{"nil", nil, "EUC-KR", "UTF-8"},
+        UTF8_EUCKR_IDX:       &eleMent{"nil", nil, "UTF-8", "EUC-KR"},
+        CP949_UTF8_IDX:       &eleMent{"nil", nil, "CP949", "UTF-8"},
+        UTF8_CP949_IDX:       &eleMent{"nil", nil, "UTF-8", "CP949"},
+        ISO2022KR_UTF8_IDX:   &eleMent{"nil", nil, "ISO-2022-KR", "UTF-8"},
+        UTF8_ISO2022KR_IDX:   &eleMent{"nil", nil, "UTF-8", "ISO-2022-KR"},
+        IBM935_GBK_IDX:       &eleMent{"nil", nil, "IBM-935", "GBK"},
+        GBK_IBM935_IDX:       &eleMent{"nil", nil, "GBK", "IBM-935"},
+        IBM935_UTF8_IDX:      &eleMent{"nil", nil, "IBM-935", "UTF-8"},
+        UTF8_IBM935_IDX:      &eleMent{"nil", nil, "UTF-8", "IBM-935"},
+        IBM1388_GBK_IDX:      &eleMent{"nil", nil, "IBM-1388", "GBK"},
+        GBK_IBM1388_IDX:      &eleMent{"nil", nil, "GBK", "IBM-1388"},
+        IBM1388_UTF8_IDX:     &eleMent{"nil", nil, "IBM-1388", "UTF-8"},
+        UTF8_IBM1388_IDX:     &eleMent{"nil", nil, "UTF-8", "IBM-1388"},
+        HZ_UTF8_IDX:          &eleMent{"nil", nil, "HZ-GB-2312", "UTF-8"},
+        UTF8_HZ_IDX:          &eleMent{"nil", nil, "UTF-8", "HZ-GB-2312"},
+        HZ_GBK_IDX:           &eleMent{"nil", nil, "HZ-GB-2312", "GBK"},
+        GBK_HZ_IDX:           &eleMent{"nil", nil, "GBK", "HZ-GB-2312"},
+        ISO2022CN_UTF8_IDX:   &eleMent{"nil", nil, "ISO-2022-CN", "UTF-8"},
+        UTF8_ISO2022CN_IDX:   &eleMent{"nil", nil, "UTF-8", "ISO-2022-CN"},
+        ISO2022CN_GBK_IDX:    &eleMent{"nil", nil, "ISO-2022-CN", "GBK"},
+        GBK_ISO2022CN_IDX:    &eleMent{"nil", nil, "GBK", "ISO-2022-CN"},
 }
 
-type Converter struct {
-        isOpen  bool
-        codeMap map[uint64]uint64
-        // The first argument is input argument, that is to be translated.
-        // The second argument is output argument, this has been translated. 
-        // The memory of the second argument should be enough big,and it should be allocated by the caller of the function
-        CodeConvertFunc func([]byte, []byte) (int, error)
+// 编码转换器. NewCoder, NewCoderByName及NewCoderAuto返回的转换器不能在多个goroutine中同时使用.
+type Converter interface {
+        // 转换in, 结果写入out, 返回写入的字节数; out应足够大(见MaxOutputPerInputByte).
+        // 字符转换失败时返回*ConvertError
+        Convert(in, out []byte) (int, error)
+        // 源编码及目标编码的名称. 源编码为UTF-16, UTF-32或由NewCoderAuto识别时,
+        // Source在转换后返回识别出的编码(如UTF-16BE)
+        Source() string
+        Target() string
+        // 每个输入字节最多输出的字节数, 不含BOM, 转义序列复位等固定开销
+        MaxOutputPerInputByte() int
+        // 一次调用转换n字节输入最多输出的字节数, 含固定开销及上次调用遗留的不完整字符
+        MaxEncodedLen(n int) int
+        // 以当前状态转换src将输出的字节数, 只计数不输出, 不改变转换状态.
+        // 转换失败时返回*ConvertError
+        EncodedLen(src []byte) (int, error)
+        // 结束一次分块的流式转换: 若最后一块输入的末尾有不完整的字符则返回错误,
+        // 并将转换状态(如ISO-2022-JP的移位状态)复位, 之后可开始新的转换.
+        Flush() error
+        // 丢弃转换状态(移位状态, 未转换完的不完整字符), 之后可开始新的转换
+        Reset()
+        // 释放对映射表的引用, 没有转换器引用的映射表从缓存中删除. 关闭后不能再转换
+        Close() error
 }
 
-func NewCoder(idx CODING_IDX) (*Converter, error) {
+var errClosed = errors.New("转换器已关闭")
+
+// 使用专门转换函数的转换器, 没有跨调用的状态
+type tableCoder struct {
+        ele      *eleMent
+        codeMap  map[uint64]uint64
+        tables   []string
+        closed   bool
+        num, den int         // 每字节最多输出num/den字节, den为0时尚未计算
+        counter  *pivotCoder // 计数用, 第一次调用EncodedLen时创建
+}
+
+func (c *tableCoder) Convert(in, out []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        return c.ele.fn(c.codeMap, in, out)
+}
+
+func (c *tableCoder) Source() string { return c.ele.src }
+func (c *tableCoder) Target() string { return c.ele.dst }
+func (c *tableCoder) Flush() error   { return nil }
+func (c *tableCoder) Reset()         {}
+
+func (c *tableCoder) ratio() (int, int) {
+        if c.den == 0 {
+                s, d := g_Charsets[c.ele.src], g_Charsets[c.ele.dst]
+                var decTbl, encTbl map[uint64]uint64
+                if s != nil && s.decFile == c.ele.filename {
+                        decTbl = c.codeMap
+                } else if d != nil && d.encFile == c.ele.filename {
+                        encTbl = c.codeMap
+                }
+                st := &codeState{decTbl: decTbl, encTbl: encTbl}
+                c.num, c.den = st.maxRatio(s, d, c.ele.src, c.ele.dst)
+        }
+        return c.num, c.den
+}
+
+func (c *tableCoder) MaxOutputPerInputByte() int {
+        return perByte(c.ratio())
+}
+
+// 专门的转换函数没有跨调用的状态, 不需要计入遗留的不完整字符
+func (c *tableCoder) MaxEncodedLen(n int) int {
+        num, den := c.ratio()
+        return maxEncodedLen(num, den, 0, fixedCost(g_Charsets[c.ele.dst], c.ele.dst), n)
+}
+
+// 按经Unicode中转的方式计数, 结果与专门的转换函数相同. 计数用的转换器不是流式的,
+// 与专门的转换函数一样, 输入末尾不完整的字符视为错误
+func (c *tableCoder) EncodedLen(src []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        if c.counter == nil {
+                // UNICODE没有逐字符的编解码, 与其占位的转换函数相同, 输出0字节
+                if g_Charsets[c.ele.src] == nil || g_Charsets[c.ele.dst] == nil {
+                        return 0, nil
+                }
+                counter, err := newPivotCoder(c.ele.src, c.ele.dst)
+                if err != nil {
+                        return 0, err
+                }
+                c.counter = counter.(*pivotCoder)
+        }
+        st := *c.counter.st
+        n, err := pivotConvert(c.counter.s, c.counter.d, &st, src, nil)
+        return n, err
+}
+
+func (c *tableCoder) Close() error {
+        if !c.closed {
+                releaseTables(c.tables)
+                if c.counter != nil {
+                        c.counter.Close()
+                }
+                c.closed = true
+        }
+        return nil
+}
+
+// opts为可选设置(如WithLossReport), 设置后一律经Unicode中转
+func NewCoder(idx CODING_IDX, opts ...Option) (Converter, error) {
         var ele *eleMent
-        ret := new(Converter)
         if v, ok := g_CodeMap[idx]; ok {
                 ele = v
         } else {
                 return nil, fmt.Errorf("Error: 未知编码格式\n")
         }
-        if ele.filename != "nil" {
-                _, filename, _, _ := runtime.Caller(0)
-                ele.filename = path.Join(path.Dir(filename), ele.filename)
-                ret.CodeConvertFunc = func(in []byte, out []byte) (int, error) {
-                        return ele.fn(ret.codeMap, in, out)
-                }
-                ret.isOpen = true
+        if ele.fn == nil || len(opts) > 0 {
+                return newPivotCoder(ele.src, ele.dst, opts...)
+        }
+        ret := &tableCoder{ele: ele}
+        if ele.filename == "nil" {
+                return ret, nil
+        }
+
+        // 映射表属于源编码(解码)或目标编码(编码)
+        var err error
+        if cs, ok := g_Charsets[ele.src]; ok && cs.decFile == ele.filename {
+                ret.codeMap, err = loadTable(ele.filename, cs.table, TableDecode)
+        } else if cs, ok := g_Charsets[ele.dst]; ok && cs.encFile == ele.filename {
+                ret.codeMap, err = loadTable(ele.filename, cs.table, TableEncode)
         } else {
+                err = fmt.Errorf("Error: 未知映射表[%s]\n", ele.filename)
+        }
+        if err != nil {
+                return nil, err
+        }
+        ret.tables = []string{ele.filename}
+
+        return ret, nil
+}
+
+type loadedTable struct {
+        name string
+        dir  TableDir
+        tbl  map[uint64]uint64
+        refs int // 引用该映射表的转换器数
+}
+
+// 已加载的映射表, 同一映射表只加载一次, 供所有转换器共享(只读).
+// 引用计数降为0时从缓存中删除, 映射表的内存在不再使用后回收
+var (
+        g_TablesLock sync.Mutex
+        g_Tables     = map[string]*loadedTable{}
+        g_TableDir   string
+)
+
+// 设置映射表文件所在目录, 用于脱离源码目录运行的程序; 为空时使用本源文件所在目录.
+// 应在创建转换器之前调用, 已加载的映射表不受影响
+func SetTableDir(dir string) {
+        g_TablesLock.Lock()
+        g_TableDir = dir
+        g_TablesLock.Unlock()
+}
 
-                ret.CodeConvertFunc = func(in []byte, out []byte) (int, error) {
-                        return ele.fn(ret.codeMap, in, out)
+//加载映射表, 映射表文件默认与本源文件位于同一目录.
+//文件格式见table.go, 文件损坏或其中的字符集名称, 方向与name, dir不符时返回错误.
+//成功时在同一次加锁中增加引用计数, 以免其它转换器Close将其从缓存中删除;
+//调用者不再使用映射表时须以releaseTables释放
+func loadTable(filename string, name string, dir TableDir) (map[uint64]uint64, error) {
+        g_TablesLock.Lock()
+        defer g_TablesLock.Unlock()
+        lt, ok := g_Tables[filename]
+        if !ok {
+                var err error
+                if lt, err = readTableFile(filename); err != nil {
+                        return nil, err
                 }
-                return ret, nil
         }
+        if lt.name != name {
+                return nil, fmt.Errorf("映射表[%s]的字符集为%s, 应为%s", filename, lt.name, name)
+        }
+        if lt.dir != dir {
+                return nil, fmt.Errorf("映射表[%s]的方向为%v, 应为%v", filename, lt.dir, dir)
+        }
+        if !ok {
+                g_Tables[filename] = lt
+        }
+        lt.refs++
+        return lt.tbl, nil
+}
 
-        rf, err := os.Open(ele.filename)
+// 释放loadTable取得的引用, files中每一项对应一次加载
+func releaseTables(files []string) {
+        g_TablesLock.Lock()
+        defer g_TablesLock.Unlock()
+        for _, f := range files {
+                if lt, ok := g_Tables[f]; ok {
+                        if lt.refs--; lt.refs <= 0 {
+                                delete(g_Tables, f)
+                        }
+                }
+        }
+}
+
+func readTableFile(filename string) (*loadedTable, error) {
+        dir := g_TableDir
+        if dir == "" {
+                _, file, _, _ := runtime.Caller(0)
+                dir = path.Dir(file)
+        }
+        data, err := os.ReadFile(path.Join(dir, filename))
         if err != nil {
                 return nil, err
         }
-        defer rf.Close()
-        ret.codeMap = make(map[uint64]uint64)
-        gb := gob.NewDecoder(rf)
-        if err := gb.Decode(&ret.codeMap); err != nil {
-                return nil, err
+        name, tdir, tbl, err := parseTable(data)
+        if err != nil {
+                return nil, fmt.Errorf("映射表[%s]: %v", filename, err)
         }
-
-        return ret, nil
+        return &loadedTable{name: name, dir: tdir, tbl: tbl}, nil
 }
 
 func isLittleEndian() bool {
@@ -128,11 +410,30 @@ func convertUTF16LEToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (in
         if fromLen%2 != 0 {
                 return 0, fmt.Errorf("无效Unicode字符串")
         }
-        if from[0] == 0xff {
+        if fromLen >= 2 && from[0] == 0xff && from[1] == 0xfe {
                 i += 2
         }
         for i < fromLen {
+                if n := utf16ASCIIPrefix(from[i:], false); n > 0 { // 连续的ASCII字符
+                        for k := 0; k < n; k += 2 {
+                                to[j] = from[i+k]
+                                j++
+                        }
+                        i += n
+                        continue
+                }
                 tmpUnicode = uint64(binary.LittleEndian.Uint16(from[i:]))
+                n := 2
+                if tmpUnicode >= 0xd800 && tmpUnicode <= 0xdfff {
+                        // 代理对解码为一个字符, 单独的代理为非法字符
+                        var err error
+                        if tmpUnicode, n, err = decodeUTF16(binary.LittleEndian, from[i:]); err != nil {
+                                if err == errShortSrc {
+                                        err = fmt.Errorf("输入末尾有%d字节不完整的字符", fromLen-i)
+                                }
+                                return 0, &ConvertError{i, j, err}
+                        }
+                }
                 switch {
                 case tmpUnicode < 0x00000080:
                         to[j] = byte(tmpUnicode)
@@ -146,31 +447,14 @@ func convertUTF16LEToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (in
                         to[j+1] = 0x80 | byte((tmpUnicode>>6)&0x3f)
                         to[j+2] = 0x80 | byte(tmpUnicode&0x3f)
                         j += 3
-                case tmpUnicode < 0x00200000:
+                default:
                         to[j] = 0xf0 | byte((tmpUnicode>>18)&0x07)
                         to[j+1] = 0x80 | byte((tmpUnicode>>12)&0x3f)
                         to[j+2] = 0x80 | byte((tmpUnicode>>6)&0x3f)
                         to[j+3] = 0x80 | byte(tmpUnicode&0x3f)
                         j += 4
-                case tmpUnicode < 0x04000000:
-                        to[j] = 0xf8 | byte((tmpUnicode>>24)&0x03)
-                        to[j+1] = 0x80 | byte((tmpUnicode>>18)&0x3f)
-                        to[j+2] = 0x80 | byte((tmpUnicode>>12)&0x3f)
-                        to[j+3] = 0x80 | byte((tmpUnicode>>6)&0x3f)
-                        to[j+4] = 0x80 | byte(tmpUnicode&0x3f)
-                        j += 5
-                case tmpUnicode < 0x80000000:
-                        to[j] = 0xfc | byte((tmpUnicode>>30)&0x01)
-                        to[j+1] = 0x80 | byte((tmpUnicode>>24)&0x3f)
-                        to[j+2] = 0x80 | byte((tmpUnicode>>18)&0x3f)
-                        to[j+3] = 0x80 | byte((tmpUnicode>>12)&0x3f)
-                        to[j+4] = 0x80 | byte((tmpUnicode>>6)&0x3f)
-                        to[j+5] = 0x80 | byte(tmpUnicode&0x3f)
-                        j += 6
-                default:
-                        return 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
                 }
-                i += 2
+                i += n
         }
 
         return j, nil
@@ -185,11 +469,30 @@ func convertUTF16BEToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (in
         if fromLen%2 != 0 {
                 return 0, fmt.Errorf("无效Unicode字符串")
         }
-        if from[0] == 0xfe {
+        if fromLen >= 2 && from[0] == 0xfe && from[1] == 0xff {
                 i += 2
         }
         for i < fromLen {
+                if n := utf16ASCIIPrefix(from[i:], true); n > 0 { // 连续的ASCII字符
+                        for k := 0; k < n; k += 2 {
+                                to[j] = from[i+k+1]
+                                j++
+                        }
+                        i += n
+                        continue
+                }
                 tmpUnicode = uint64(binary.BigEndian.Uint16(from[i:]))
+                n := 2
+                if tmpUnicode >= 0xd800 && tmpUnicode <= 0xdfff {
+                        // 代理对解码为一个字符, 单独的代理为非法字符
+                        var err error
+                        if tmpUnicode, n, err = decodeUTF16(binary.BigEndian, from[i:]); err != nil {
+                                if err == errShortSrc {
+                                        err = fmt.Errorf("输入末尾有%d字节不完整的字符", fromLen-i)
+                                }
+                                return 0, &ConvertError{i, j, err}
+                        }
+                }
                 switch {
                 case tmpUnicode < 0x00000080:
                         to[j] = byte(tmpUnicode)
@@ -203,81 +506,137 @@ func convertUTF16BEToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (in
                         to[j+1] = 0x80 | byte((tmpUnicode>>6)&0x3f)
                         to[j+2] = 0x80 | byte(tmpUnicode&0x3f)
                         j += 3
-                case tmpUnicode < 0x00200000:
+                default:
                         to[j] = 0xf0 | byte((tmpUnicode>>18)&0x07)
                         to[j+1] = 0x80 | byte((tmpUnicode>>12)&0x3f)
                         to[j+2] = 0x80 | byte((tmpUnicode>>6)&0x3f)
                         to[j+3] = 0x80 | byte(tmpUnicode&0x3f)
                         j += 4
-                case tmpUnicode < 0x04000000:
-                        to[j] = 0xf8 | byte((tmpUnicode>>24)&0x03)
-                        to[j+1] = 0x80 | byte((tmpUnicode>>18)&0x3f)
-                        to[j+2] = 0x80 | byte((tmpUnicode>>12)&0x3f)
-                        to[j+3] = 0x80 | byte((tmpUnicode>>6)&0x3f)
-                        to[j+4] = 0x80 | byte(tmpUnicode&0x3f)
-                        j += 5
-                case tmpUnicode < 0x80000000:
-                        to[j] = 0xfc | byte((tmpUnicode>>30)&0x01)
-                        to[j+1] = 0x80 | byte((tmpUnicode>>24)&0x3f)
-                        to[j+2] = 0x80 | byte((tmpUnicode>>18)&0x3f)
-                        to[j+3] = 0x80 | byte((tmpUnicode>>12)&0x3f)
-                        to[j+4] = 0x80 | byte((tmpUnicode>>6)&0x3f)
-                        to[j+5] = 0x80 | byte(tmpUnicode&0x3f)
-                        j += 6
-                default:
-                        return 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
                 }
-                i += 2
+                i += n
         }
 
         return j, nil
 }
 
+// 判断UTF-16字符串的字节序, 返回UTF16_LE_UTF8_IDX或UTF16_BE_UTF8_IDX.
+// 有BOM时以BOM为准(bom为true); 无BOM时根据偶数位与奇数位上零字节
+// 的分布推断, 无法区分时按Windows习惯视为小端.
+func DetectUTF16(from []byte) (idx CODING_IDX, bom bool) {
+        if len(from) >= 2 {
+                switch {
+                case from[0] == 0xff && from[1] == 0xfe:
+                        return UTF16_LE_UTF8_IDX, true
+                case from[0] == 0xfe && from[1] == 0xff:
+                        return UTF16_BE_UTF8_IDX, true
+                }
+        }
+        evenZero := 0
+        oddZero := 0
+        for i := 0; i+1 < len(from) && i < 4096; i += 2 {
+                if from[i] == 0 {
+                        evenZero++
+                }
+                if from[i+1] == 0 {
+                        oddZero++
+                }
+        }
+        if evenZero > oddZero {
+                return UTF16_BE_UTF8_IDX, false
+        }
+        return UTF16_LE_UTF8_IDX, false
+}
+
+//按UTF-8首字节逐字符扫描, 返回扫描到的位置, 等于len(from)时结构完整
+func scanUTF8(from []byte) (int, error) {
+        i := 0
+        fromLen := len(from)
+        for i < fromLen {
+                switch {
+                case 0x80&from[i] == 0x0:
+                        i += asciiPrefix(from[i:])
+                case 0xe0&from[i] == 0xc0:
+                        i += 2
+                case 0xf0&from[i] == 0xe0:
+                        i += 3
+                case 0xf8&from[i] == 0xf0:
+                        i += 4
+                case 0xfc&from[i] == 0xf8:
+                        i += 5
+                case 0xfe&from[i] == 0xfc:
+                        i += 6
+                default:
+                        return i, errors.New("无效UTF-8字符")
+                }
+        }
+        return i, nil
+}
+
+//解码from开头的一个非ASCII的UTF-8字符, 返回码点及长度, 不合法或不完整时长度为0
+func utf8Char(from []byte) (uint64, int) {
+        var code uint64
+        var n int
+        b := from[0]
+        switch {
+        case 0xe0&b == 0xc0:
+                code, n = uint64(b&0x1f), 2
+        case 0xf0&b == 0xe0:
+                code, n = uint64(b&0x0f), 3
+        case 0xf8&b == 0xf0:
+                code, n = uint64(b&0x07), 4
+        case 0xfc&b == 0xf8:
+                code, n = uint64(b&0x03), 5
+        case 0xfe&b == 0xfc:
+                code, n = uint64(b&0x01), 6
+        default:
+                return 0, 0
+        }
+        if len(from) < n {
+                return 0, 0
+        }
+        for _, c := range from[1:n] {
+                if c&0xc0 != 0x80 {
+                        return 0, 0
+                }
+                code = code<<6 | uint64(c&0x3f)
+        }
+        return code, n
+}
+
 //将GBK编码转换为UTF-8编码
 func convertGBKToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (int, error) {
         i := 0
+        j := 0
         var tmpGbk uint64
         var tmpUnicode uint64
 
         fromLen := len(from)
         for i < fromLen {
-                if from[i]&0x80 == 0 { // ascii
-                        i++
-                } else {
-                        if from[i+1] > 0x39 || from[i+1] < 0x30 {
-                                i += 2
-                        } else {
-                                i += 4
-                        }
+                if from[i] < 0x80 { // 连续的ASCII字符直接复制
+                        n := asciiPrefix(from[i:])
+                        j += copy(to[j:j+n], from[i:i+n])
+                        i += n
+                        continue
                 }
-        }
-        if i != fromLen {
-                return 0, errors.New("非法GBK编码")
-        }
-        i = 0
-        j := 0
-        for i < fromLen {
-                tmpGbk = 0x0
-                if from[i]&0x80 == 0 { // ascii
-                        tmpGbk = uint64(from[i])
-                        i++
+                var n int
+                if i+1 < fromLen && from[i] > 0x80 && from[i] < 0xff && from[i+1] >= 0x40 && from[i+1] <= 0xfe && from[i+1] != 0x7f {
+                        // 双字节字符
+                        n = 2
                 } else {
-                        if from[i+1] > 0x39 || from[i+1] < 0x30 {
-                                tmpGbk = uint64(from[i+1])
-                                tmpGbk |= uint64(from[i]) << 8
-                                i += 2
-                        } else {
-                                tmpGbk = uint64(from[i+3])
-                                tmpGbk |= uint64(from[i+2]) << 8
-                                tmpGbk |= uint64(from[i+1]) << 16
-                                tmpGbk |= uint64(from[i]) << 24
-                                i += 4
-                        }
+                        n = gbCharLen(from[i:], true)
+                }
+                switch n {
+                case 2:
+                        tmpGbk = uint64(from[i])<<8 | uint64(from[i+1])
+                case 4:
+                        tmpGbk = uint64(from[i])<<24 | uint64(from[i+1])<<16 | uint64(from[i+2])<<8 | uint64(from[i+3])
+                default:
+                        return 0, &ConvertError{i, j, fmt.Errorf("非法GBK编码[0x%x]", from[i])}
                 }
                 if v, ok := tbl_map[tmpGbk]; ok {
                         tmpUnicode = v
                 } else {
-                        return 0, fmt.Errorf("未找到对应字符[0x%x]", tmpGbk)
+                        return 0, &ConvertError{i, j, fmt.Errorf("未找到对应字符[0x%x]", tmpGbk)}
                 }
                 switch {
                 case tmpUnicode < 0x00000080:
@@ -314,8 +673,9 @@ func convertGBKToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (int, e
                         to[j+5] = 0x80 | byte(tmpUnicode&0x3f)
                         j += 6
                 default:
-                        return 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
+                        return 0, &ConvertError{i, j, fmt.Errorf("非法字符[0x%x]", tmpUnicode)}
                 }
+                i += n
         }
         return j, nil
 }
@@ -323,67 +683,45 @@ func convertGBKToUTF8(tbl_map map[uint64]uint64, from []byte, to []byte) (int, e
 func convertUTF8ToUTF16LE(tbl_map map[uint64]uint64, from []byte, to []byte) (int, error) {
         i := 0
         j := 0
-        var tmpUnicode uint64
         fromLen := len(from)
-        for i < fromLen {
-                switch {
-                case 0x80&from[i] == 0x0:
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        i += 6
-                default:
-                        return 0, errors.New("无效UTF-8字符")
-                }
-        }
-        if i != fromLen {
-                return 0, errors.New("无效UTF-8字符串")
-        }
-        i = 0
         // -------------------------------------
         to[j] = 0xff
         to[j+1] = 0xfe
         j += 2
         // -------------------------------------
         for i < fromLen {
-                tmpUnicode = 0x00
-                switch {
-                case 0x80&from[i] == 0x00:
-                        tmpUnicode = uint64(from[i])
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        tmpUnicode |= (uint64(from[i]&0x1f) << 6) | uint64(from[i+1]&0x3f)
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        tmpUnicode |= (uint64(from[i]&0x0f) << 12) | (uint64(from[i+1]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+2] & 0x3f)
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        tmpUnicode |= (uint64(from[i]&0x07) << 18) | (uint64(from[i+1]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 6) | uint64(from[i+3]&0x3f)
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        tmpUnicode |= (uint64(from[i]&0x03) << 24) | (uint64(from[i+1]&0x3f) << 18)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 12) | (uint64(from[i+3]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+4] & 0x3f)
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        tmpUnicode |= (uint64(from[i]&0x01) << 30) | (uint64(from[i+1]&0x3f) << 24)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 18) | (uint64(from[i+3]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+4]&0x3f) << 6) | uint64(from[i+5]&0x3f)
-                        i += 6
-                default:
-                        return 0, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])
+                if from[i] < 0x80 { // 连续的ASCII字符
+                        n := asciiPrefix(from[i:])
+                        for _, b := range from[i : i+n] {
+                                to[j] = b
+                                to[j+1] = 0
+                                j += 2
+                        }
+                        i += n
+                        continue
                 }
-                binary.LittleEndian.PutUint16(to[j:], uint16(tmpUnicode))
-                j += 2
+                var tmpUnicode uint64
+                var n int
+                if i+2 < fromLen && from[i]&0xf0 == 0xe0 && from[i+1]&0xc0 == 0x80 && from[i+2]&0xc0 == 0x80 {
+                        // 三字节字符(含全部常用汉字)
+                        tmpUnicode = uint64(from[i]&0x0f)<<12 | uint64(from[i+1]&0x3f)<<6 | uint64(from[i+2]&0x3f)
+                        n = 3
+                } else if tmpUnicode, n = utf8Char(from[i:]); n == 0 {
+                        return 0, &ConvertError{i, j, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])}
+                }
+                if tmpUnicode < 0xd800 || (tmpUnicode > 0xdfff && tmpUnicode < 0x10000) {
+                        binary.LittleEndian.PutUint16(to[j:], uint16(tmpUnicode))
+                        i += n
+                        j += 2
+                        continue
+                }
+                // 基本多文种平面以外的字符编码为代理对, 代理区的码点为非法字符
+                m, err := encodeUTF16(binary.LittleEndian, tmpUnicode, to[j:])
+                if err != nil {
+                        return 0, &ConvertError{i, j, err}
+                }
+                i += n
+                j += m
         }
 
         return j, nil
@@ -392,67 +730,45 @@ func convertUTF8ToUTF16LE(tbl_map map[uint64]uint64, from []byte, to []byte) (in
 func convertUTF8ToUTF16BE(tbl_map map[uint64]uint64, from []byte, to []byte) (int, error) {
         i := 0
         j := 0
-        var tmpUnicode uint64
         fromLen := len(from)
-        for i < fromLen {
-                switch {
-                case 0x80&from[i] == 0x0:
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        i += 6
-                default:
-                        return 0, errors.New("无效UTF-8字符")
-                }
-        }
-        if i != fromLen {
-                return 0, errors.New("无效UTF-8字符串")
-        }
-        i = 0
         // -------------------------------------
         to[j] = 0xfe
         to[j+1] = 0xff
         j += 2
         // -------------------------------------
         for i < fromLen {
-                tmpUnicode = 0x00
-                switch {
-                case 0x80&from[i] == 0x00:
-                        tmpUnicode = uint64(from[i])
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        tmpUnicode |= (uint64(from[i]&0x1f) << 6) | uint64(from[i+1]&0x3f)
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        tmpUnicode |= (uint64(from[i]&0x0f) << 12) | (uint64(from[i+1]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+2] & 0x3f)
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        tmpUnicode |= (uint64(from[i]&0x07) << 18) | (uint64(from[i+1]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 6) | uint64(from[i+3]&0x3f)
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        tmpUnicode |= (uint64(from[i]&0x03) << 24) | (uint64(from[i+1]&0x3f) << 18)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 12) | (uint64(from[i+3]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+4] & 0x3f)
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        tmpUnicode |= (uint64(from[i]&0x01) << 30) | (uint64(from[i+1]&0x3f) << 24)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 18) | (uint64(from[i+3]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+4]&0x3f) << 6) | uint64(from[i+5]&0x3f)
-                        i += 6
-                default:
-                        return 0, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])
+                if from[i] < 0x80 { // 连续的ASCII字符
+                        n := asciiPrefix(from[i:])
+                        for _, b := range from[i : i+n] {
+                                to[j] = 0
+                                to[j+1] = b
+                                j += 2
+                        }
+                        i += n
+                        continue
+                }
+                var tmpUnicode uint64
+                var n int
+                if i+2 < fromLen && from[i]&0xf0 == 0xe0 && from[i+1]&0xc0 == 0x80 && from[i+2]&0xc0 == 0x80 {
+                        // 三字节字符(含全部常用汉字)
+                        tmpUnicode = uint64(from[i]&0x0f)<<12 | uint64(from[i+1]&0x3f)<<6 | uint64(from[i+2]&0x3f)
+                        n = 3
+                } else if tmpUnicode, n = utf8Char(from[i:]); n == 0 {
+                        return 0, &ConvertError{i, j, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])}
+                }
+                if tmpUnicode < 0xd800 || (tmpUnicode > 0xdfff && tmpUnicode < 0x10000) {
+                        binary.BigEndian.PutUint16(to[j:], uint16(tmpUnicode))
+                        i += n
+                        j += 2
+                        continue
+                }
+                // 基本多文种平面以外的字符编码为代理对, 代理区的码点为非法字符
+                m, err := encodeUTF16(binary.BigEndian, tmpUnicode, to[j:])
+                if err != nil {
+                        return 0, &ConvertError{i, j, err}
                 }
-                binary.BigEndian.PutUint16(to[j:], uint16(tmpUnicode))
-                j += 2
+                i += n
+                j += m
         }
 
         return j, nil
@@ -460,67 +776,30 @@ func convertUTF8ToUTF16BE(tbl_map map[uint64]uint64, from []byte, to []byte) (in
 
 //将UTF-8编码转换为GBK编码
 func convertUTF8ToGBK(tbl_map map[uint64]uint64, from []byte, to []byte) (int, error) {
-        var tmpUnicode uint64
         var tmpGbk uint64
-        i := 0
         fromLen := len(from)
-        for i < fromLen {
-                switch {
-                case 0x80&from[i] == 0x0:
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        i += 6
-                default:
-                        return 0, errors.New("无效UTF-8字符")
-                }
-        }
-        if i != fromLen {
-                return 0, errors.New("无效长度")
-        }
-        i = 0
+        i := 0
         j := 0
         for i < fromLen {
-                tmpUnicode = 0x00
-                switch {
-                case 0x80&from[i] == 0x00:
-                        tmpUnicode = uint64(from[i])
-                        i++
-                case 0xe0&from[i] == 0xc0:
-                        tmpUnicode |= (uint64(from[i]&0x1f) << 6) | uint64(from[i+1]&0x3f)
-                        i += 2
-                case 0xf0&from[i] == 0xe0:
-                        tmpUnicode |= (uint64(from[i]&0x0f) << 12) | (uint64(from[i+1]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+2] & 0x3f)
-                        i += 3
-                case 0xf8&from[i] == 0xf0:
-                        tmpUnicode |= (uint64(from[i]&0x07) << 18) | (uint64(from[i+1]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 6) | uint64(from[i+3]&0x3f)
-                        i += 4
-                case 0xfc&from[i] == 0xf8:
-                        tmpUnicode |= (uint64(from[i]&0x03) << 24) | (uint64(from[i+1]&0x3f) << 18)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 12) | (uint64(from[i+3]&0x3f) << 6)
-                        tmpUnicode |= uint64(from[i+4] & 0x3f)
-                        i += 5
-                case 0xfe&from[i] == 0xfc:
-                        tmpUnicode |= (uint64(from[i]&0x01) << 30) | (uint64(from[i+1]&0x3f) << 24)
-                        tmpUnicode |= (uint64(from[i+2]&0x3f) << 18) | (uint64(from[i+3]&0x3f) << 12)
-                        tmpUnicode |= (uint64(from[i+4]&0x3f) << 6) | uint64(from[i+5]&0x3f)
-                        i += 6
-                default:
-                        return 0, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])
+                if from[i] < 0x80 { // 连续的ASCII字符直接复制
+                        n := asciiPrefix(from[i:])
+                        j += copy(to[j:j+n], from[i:i+n])
+                        i += n
+                        continue
+                }
+                var tmpUnicode uint64
+                var n int
+                if i+2 < fromLen && from[i]&0xf0 == 0xe0 && from[i+1]&0xc0 == 0x80 && from[i+2]&0xc0 == 0x80 {
+                        // 三字节字符(含全部常用汉字)
+                        tmpUnicode = uint64(from[i]&0x0f)<<12 | uint64(from[i+1]&0x3f)<<6 | uint64(from[i+2]&0x3f)
+                        n = 3
+                } else if tmpUnicode, n = utf8Char(from[i:]); n == 0 {
+                        return 0, &ConvertError{i, j, fmt.Errorf("无效UTF-8字符[0x%x]", from[i])}
                 }
                 if v, ok := tbl_map[tmpUnicode]; ok {
                         tmpGbk = v
                 } else {
-                        return 0, fmt.Errorf("未找到对应字符[0x%x]", tmpUnicode)
+                        return 0, &ConvertError{i, j, fmt.Errorf("未找到对应字符[0x%x]", tmpUnicode)}
                 }
                 switch {
                 case tmpGbk < 0x80:
@@ -537,8 +816,9 @@ func convertUTF8ToGBK(tbl_map map[uint64]uint64, from []byte, to []byte) (int, e
                         to[j+3] = byte(tmpGbk)
                         j += 4
                 default:
-                        return 0, fmt.Errorf("非法对应字符[0x%x]", tmpGbk)
+                        return 0, &ConvertError{i, j, fmt.Errorf("非法对应字符[0x%x]", tmpGbk)}
                 }
+                i += n
         }
         return j, nil
 }
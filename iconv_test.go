@@ -0,0 +1,1082 @@
+package better
+
+import (
+        "bytes"
+        "errors"
+        "strings"
+        "testing"
+)
+
+// 含ASCII, GB2312汉字及全角标点的输入, GB系列及Unicode编码都能表示
+var testText = []byte("{\"id\": 42, \"name\": \"中华人民共和国\", \"addr\": \"北京市海淀区\"}，测试。")
+
+func mustCoder(t testing.TB, idx CODING_IDX) Converter {
+        c, err := NewCoder(idx)
+        if err != nil {
+                t.Fatal(err)
+        }
+        return c
+}
+
+func mustConvert(t testing.TB, from, to string, src []byte) []byte {
+        c, err := NewCoderByName(from, to)
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        out, err := AppendConvert(c, nil, src)
+        if err != nil {
+                t.Fatal(err)
+        }
+        return out
+}
+
+func TestConvertAllocs(t *testing.T) {
+        gbk := mustConvert(t, "UTF-8", "GBK", testText)
+        for _, tc := range []struct {
+                idx CODING_IDX
+                src []byte
+        }{
+                {GBK_UTF8_IDX, gbk},
+                {UTF8_GBK_IDX, testText},
+        } {
+                c := mustCoder(t, tc.idx)
+                out := make([]byte, c.MaxEncodedLen(len(tc.src)))
+                if n := testing.AllocsPerRun(100, func() {
+                        if _, err := c.Convert(tc.src, out); err != nil {
+                                t.Fatal(err)
+                        }
+                }); n != 0 {
+                        t.Errorf("%s->%s Convert: %v allocs", c.Source(), c.Target(), n)
+                }
+                buf, _ := AppendConvert(c, nil, tc.src)
+                if n := testing.AllocsPerRun(100, func() { buf, _ = AppendConvert(c, buf[:0], tc.src) }); n != 0 {
+                        t.Errorf("%s->%s AppendConvert: %v allocs", c.Source(), c.Target(), n)
+                }
+                fn := func(out []byte) error { return nil }
+                ConvertWith(c, tc.src, fn)
+                if n := testing.AllocsPerRun(100, func() {
+                        if err := ConvertWith(c, tc.src, fn); err != nil {
+                                t.Fatal(err)
+                        }
+                }); n != 0 {
+                        t.Errorf("%s->%s ConvertWith: %v allocs", c.Source(), c.Target(), n)
+                }
+                c.Close()
+        }
+}
+
+// 经Unicode中转的转换在输入为完整字符且没有处理阶段时也不分配
+func TestPivotAllocs(t *testing.T) {
+        for _, pr := range [][2]string{{"GBK", "UTF-8"}, {"UTF-8", "GB18030"}, {"UTF-8", "UTF-16"}, {"UTF-8", "HZ-GB-2312"}, {"UTF-16LE", "GB18030"}} {
+                src := testText
+                if pr[0] != "UTF-8" {
+                        src = mustConvert(t, "UTF-8", pr[0], testText)
+                }
+                for _, stream := range []bool{false, true} {
+                        var opts []Option
+                        if stream {
+                                opts = append(opts, WithStream())
+                        }
+                        c, err := NewCoderByName(pr[0], pr[1], opts...)
+                        if err != nil {
+                                t.Fatal(err)
+                        }
+                        buf, _ := AppendConvert(c, nil, src)
+                        if n := testing.AllocsPerRun(100, func() { buf, _ = AppendConvert(c, buf[:0], src) }); n != 0 {
+                                t.Errorf("%s->%s stream=%v: %v allocs", pr[0], pr[1], stream, n)
+                        }
+                        c.Close()
+                }
+        }
+}
+
+func TestConvertWithResult(t *testing.T) {
+        c := mustCoder(t, UTF8_GBK_IDX)
+        defer c.Close()
+        want := mustConvert(t, "UTF-8", "GBK", testText)
+        err := ConvertWith(c, testText, func(out []byte) error {
+                if !bytes.Equal(out, want) {
+                        t.Errorf("got % x, want % x", out, want)
+                }
+                return nil
+        })
+        if err != nil {
+                t.Fatal(err)
+        }
+}
+
+// 大数据转换后不应把超过上限的缓冲区放回池中
+func TestConvertWithLargeNotPooled(t *testing.T) {
+        c := mustCoder(t, UTF8_GBK_IDX)
+        defer c.Close()
+        src := bytes.Repeat(testText, maxPooledBuf/len(testText)+1)
+        err := ConvertWith(c, src, func(out []byte) error {
+                if cap(out) <= maxPooledBuf {
+                        t.Fatalf("输出缓冲区容量%d未超过上限", cap(out))
+                }
+                return nil
+        })
+        if err != nil {
+                t.Fatal(err)
+        }
+        bp := g_BufPool.Get().(*[]byte)
+        defer g_BufPool.Put(bp)
+        if cap(*bp) > maxPooledBuf {
+                t.Errorf("池中缓冲区容量%d超过上限%d", cap(*bp), maxPooledBuf)
+        }
+}
+
+func TestUTF8ToUTF16Surrogates(t *testing.T) {
+        for _, tc := range []struct {
+                idx  CODING_IDX
+                want []byte
+        }{
+                {UTF8_UTF16_LE_IDX, []byte{0xff, 0xfe, 0x61, 0x00, 0x3d, 0xd8, 0x00, 0xde, 0x2d, 0x4e}},
+                {UTF8_UTF16_BE_IDX, []byte{0xfe, 0xff, 0x00, 0x61, 0xd8, 0x3d, 0xde, 0x00, 0x4e, 0x2d}},
+        } {
+                c := mustCoder(t, tc.idx)
+                out, err := AppendConvert(c, nil, []byte("a😀中"))
+                if err != nil || !bytes.Equal(out, tc.want) {
+                        t.Errorf("%s: got % x %v, want % x", c.Target(), out, err, tc.want)
+                }
+                c.Close()
+        }
+}
+
+// 出错时返回0及*ConvertError, 输出的前Written字节为输入前Offset字节的转换结果
+func TestConvertErrorPrefix(t *testing.T) {
+        gbk := mustConvert(t, "UTF-8", "GBK", []byte("ab中"))
+        for _, tc := range []struct {
+                idx     CODING_IDX
+                src     []byte
+                offset  int
+                written int
+        }{
+                {GBK_UTF8_IDX, append(gbk, 0x81, 0x20), 4, 5},
+                {UTF8_GBK_IDX, []byte("ab中\xff"), 5, 4},
+                {UTF8_UTF16_LE_IDX, []byte("ab中\xff"), 5, 8},
+                {UTF8_UTF16_BE_IDX, []byte("ab中\xff"), 5, 8},
+                {UTF8_UTF16_LE_IDX, []byte("a😀\xed\xa0\x80"), 5, 8}, // UTF-8编码的代理码点
+        } {
+                c := mustCoder(t, tc.idx)
+                out := make([]byte, c.MaxEncodedLen(len(tc.src)))
+                n, err := c.Convert(tc.src, out)
+                var ce *ConvertError
+                if n != 0 || !errors.As(err, &ce) {
+                        t.Fatalf("%s->%s: n=%d err=%v", c.Source(), c.Target(), n, err)
+                }
+                if ce.Offset != tc.offset || ce.Written != tc.written {
+                        t.Errorf("%s->%s: Offset=%d Written=%d, want %d %d", c.Source(), c.Target(), ce.Offset, ce.Written, tc.offset, tc.written)
+                }
+                prefix, err := AppendConvert(c, nil, tc.src[:tc.offset])
+                if err != nil || !bytes.Equal(out[:ce.Written], prefix) {
+                        t.Errorf("%s->%s: partial output % x, want % x", c.Source(), c.Target(), out[:ce.Written], prefix)
+                }
+                c.Close()
+        }
+}
+
+func TestUTF16ToUTF8Surrogates(t *testing.T) {
+        for _, tc := range []struct {
+                idx CODING_IDX
+                src []byte
+        }{
+                {UTF16_LE_UTF8_IDX, []byte{0x61, 0x00, 0x3d, 0xd8, 0x00, 0xde, 0x2d, 0x4e}},
+                {UTF16_BE_UTF8_IDX, []byte{0x00, 0x61, 0xd8, 0x3d, 0xde, 0x00, 0x4e, 0x2d}},
+        } {
+                c := mustCoder(t, tc.idx)
+                out, err := AppendConvert(c, nil, tc.src)
+                if err != nil || string(out) != "a😀中" {
+                        t.Errorf("%s: got %q %v", c.Source(), out, err)
+                }
+                // 单独的高代理, 单独的低代理及末尾不完整的代理对(按小端序给出)
+                for _, bad := range []struct {
+                        src     []byte
+                        offset  int
+                        written int
+                }{
+                        {[]byte{0x62, 0x00, 0x3d, 0xd8, 0x61, 0x00}, 2, 1},
+                        {[]byte{0x62, 0x00, 0x00, 0xde, 0x61, 0x00}, 2, 1},
+                        {[]byte{0x62, 0x00, 0x61, 0x00, 0x3d, 0xd8}, 4, 2},
+                } {
+                        src := append([]byte(nil), bad.src...)
+                        if tc.idx == UTF16_BE_UTF8_IDX {
+                                for k := 0; k < len(src); k += 2 {
+                                        src[k], src[k+1] = src[k+1], src[k]
+                                }
+                        }
+                        n, err := c.Convert(src, make([]byte, c.MaxEncodedLen(len(src))))
+                        var ce *ConvertError
+                        if n != 0 || !errors.As(err, &ce) || ce.Offset != bad.offset || ce.Written != bad.written {
+                                t.Errorf("%s % x: n=%d err=%v", c.Source(), src, n, err)
+                        }
+                }
+                c.Close()
+        }
+}
+
+// 专门的转换函数与EncodedLen(经Unicode中转计数)的结果一致
+func TestEncodedLenMatchesConvert(t *testing.T) {
+        // 依次尝试, 取源编码和目标编码都能表示的第一个
+        texts := []string{"a😀中文€é", "a中文测试€é", "a中文測試", "a中文", "abc"}
+        for idx, ele := range g_CodeMap {
+                c := mustCoder(t, idx)
+                tried := 0
+                for _, text := range texts {
+                        src := []byte(text)
+                        if ele.src != "UTF-8" && g_Charsets[ele.src] != nil {
+                                var err error
+                                if src, err = convertFromUTF8(ele.src, src); err != nil {
+                                        continue
+                                }
+                        }
+                        out := make([]byte, c.MaxEncodedLen(len(src)))
+                        n, err := c.Convert(src, out)
+                        if err != nil {
+                                continue
+                        }
+                        tried++
+                        if m, err := c.EncodedLen(src); err != nil || m != n {
+                                t.Errorf("%s->%s %q: EncodedLen=%d %v, Convert=%d", ele.src, ele.dst, text, m, err, n)
+                        }
+                }
+                if tried == 0 {
+                        t.Errorf("%s->%s: no test input", ele.src, ele.dst)
+                }
+                c.Close()
+        }
+}
+
+// 由UTF-8转换为name编码, 不支持的编码返回错误
+func convertFromUTF8(name string, src []byte) ([]byte, error) {
+        c, err := NewCoderByName("UTF-8", name)
+        if err != nil {
+                return nil, err
+        }
+        defer c.Close()
+        return AppendConvert(c, nil, src)
+}
+
+// 非流式转换的每次调用互不影响: 末尾不完整的字符是错误, 解码的移位状态不带到下次调用
+func TestNonStreamIndependent(t *testing.T) {
+        for _, tc := range []struct {
+                from, to string
+                first    string
+                offset   int // first出错的偏移, 不出错时为-1
+        }{
+                {"UTF-8", "BIG5", "中\xe6\x96", 3},
+                {"UTF-8", "GBK", "中\xe6", 3},
+                {"ISO-2022-JP", "UTF-8", "\x1b$B0!", -1},
+                {"HZ-GB-2312", "UTF-8", "~{VP", -1},
+        } {
+                c, err := NewCoderByName(tc.from, tc.to)
+                if err != nil {
+                        t.Fatal(err)
+                }
+                out := make([]byte, c.MaxEncodedLen(len(tc.first)))
+                _, err = c.Convert([]byte(tc.first), out)
+                var ce *ConvertError
+                switch {
+                case tc.offset < 0 && err != nil:
+                        t.Errorf("%s->%s %q: %v", tc.from, tc.to, tc.first, err)
+                case tc.offset >= 0 && (!errors.As(err, &ce) || ce.Offset != tc.offset):
+                        t.Errorf("%s->%s %q: err=%v, want incomplete input at %d", tc.from, tc.to, tc.first, err, tc.offset)
+                }
+                // 第二次调用的结果与新建的转换器相同
+                if got, err := AppendConvert(c, nil, []byte("ab")); err != nil || string(got) != string(mustConvert(t, "UTF-8", tc.to, []byte("ab"))) {
+                        t.Errorf("%s->%s: second call got %q %v", tc.from, tc.to, got, err)
+                }
+                c.Close()
+        }
+}
+
+// UTF-16(按BOM或零字节分布识别字节序)的输入为奇数字节时, 末字节是不完整的字符
+func TestUTF16OddLength(t *testing.T) {
+        c := mustCoder(t, UTF16_UTF8_IDX)
+        defer c.Close()
+        for _, tc := range []struct {
+                src    []byte
+                offset int
+        }{
+                {[]byte{0xff}, 0},
+                {[]byte{0x61, 0x00, 0x62}, 2},
+                {[]byte{0xfe, 0xff, 0x00, 0x61, 0x4e}, 4},
+        } {
+                n, err := c.Convert(tc.src, make([]byte, c.MaxEncodedLen(len(tc.src))))
+                var ce *ConvertError
+                if n != 0 || !errors.As(err, &ce) || ce.Offset != tc.offset || !errors.Is(err, errShortSrc) {
+                        t.Errorf("% x: n=%d err=%v, want incomplete input at %d", tc.src, n, err, tc.offset)
+                }
+        }
+}
+
+// 流式转换时不完整的字符及移位状态保留到下次调用, Flush时报告剩余的不完整字符
+func TestStreamCarryOver(t *testing.T) {
+        c, err := NewCoderByName("UTF-8", "BIG5", WithStream())
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        a, err := AppendConvert(c, nil, []byte("中\xe6\x96"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        b, err := AppendConvert(c, a, []byte("\x87"))
+        if err != nil || string(b) != string(mustConvert(t, "UTF-8", "BIG5", []byte("中文"))) {
+                t.Errorf("got % x %v", b, err)
+        }
+        AppendConvert(c, nil, []byte("\xe6"))
+        if err := c.Flush(); err == nil {
+                t.Error("Flush accepted an incomplete character")
+        }
+        j, err := NewCoderByName("ISO-2022-JP", "UTF-8", WithStream())
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer j.Close()
+        a, _ = AppendConvert(j, nil, []byte("\x1b$B0!"))
+        if b, err = AppendConvert(j, a, []byte("0!\x1b(B")); err != nil || string(b) != "亜亜" {
+                t.Errorf("got %q %v", b, err)
+        }
+}
+
+// 在每个字节位置把src分为两次WithStream调用转换, 结果与want相同且Flush后没有剩余
+func checkStreamSplit(t *testing.T, from, to string, src []byte, want string) {
+        t.Helper()
+        c, err := NewCoderByName(from, to, WithStream())
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        for cut := 0; cut <= len(src); cut++ {
+                a, err := AppendConvert(c, nil, src[:cut])
+                if err != nil {
+                        t.Fatalf("%s->%s cut %d: %v", from, to, cut, err)
+                }
+                b, err := AppendConvert(c, a, src[cut:])
+                if err != nil || string(b) != want {
+                        t.Errorf("%s->%s cut %d: got %q %v, want %q", from, to, cut, b, err, want)
+                }
+                if err := c.Flush(); err != nil {
+                        t.Errorf("%s->%s cut %d: Flush: %v", from, to, cut, err)
+                }
+        }
+}
+
+// 由UTF-8编码为name后与enc相同, 解码回UTF-8与text相同, 分块流式解码的结果不变
+func checkRoundTrip(t *testing.T, name, text, enc string) {
+        t.Helper()
+        if got := mustConvert(t, "UTF-8", name, []byte(text)); string(got) != enc {
+                t.Errorf("UTF-8->%s %q: got %q, want %q", name, text, got, enc)
+        }
+        if got := mustConvert(t, name, "UTF-8", []byte(enc)); string(got) != text {
+                t.Errorf("%s->UTF-8 %q: got %q, want %q", name, enc, got, text)
+        }
+        checkStreamSplit(t, name, "UTF-8", []byte(enc), text)
+}
+
+// Big5及Big5-HKSCS的往返转换, HKSCS的辅助平面字符及组合字符序列
+func TestBig5(t *testing.T) {
+        for _, tc := range []struct{ name, text, enc string }{
+                {"BIG5", "a臺灣中文", "a\xbbO\xc6W\xa4\xa4\xa4\xe5"},
+                {"BIG5-HKSCS", "中文𧉧", "\xa4\xa4\xa4\xe5\x87E"},
+                {"BIG5-HKSCS", "Ê̄ê̌Êx", "\x88b\x88\xa5\x88fx"},
+        } {
+                checkRoundTrip(t, tc.name, tc.text, tc.enc)
+        }
+        // 组合字符序列只在映射表中有对应时作为一个字符编码
+        for _, tc := range []struct{ text, enc string }{
+                {"ÊxÊ̄", "\x88fx\x88b"},
+                {"Ê̄̄", ""},
+        } {
+                got, err := convertFromUTF8("BIG5-HKSCS", []byte(tc.text))
+                if tc.enc == "" && err == nil || tc.enc != "" && (err != nil || string(got) != tc.enc) {
+                        t.Errorf("%q: got %q %v, want %q", tc.text, got, err, tc.enc)
+                }
+        }
+        if _, err := convertFromUTF8("BIG5", []byte("𧉧")); err == nil {
+                t.Error("BIG5 encoded an HKSCS character")
+        }
+}
+
+// 日文编码的往返转换, CP932的NEC/IBM扩展字符及ISO-2022-JP的移位状态
+func TestJapanese(t *testing.T) {
+        for _, tc := range []struct{ name, text, enc string }{
+                {"SHIFT_JIS", "a日本ｱ①ⅰ髙", "a\x93\xfa\x96{\xb1\x87@\xee\xef\xee\xe0"},
+                {"CP932", "日本ｱ①ⅰ髙\ue000", "\x93\xfa\x96{\xb1\x87@\xee\xef\xee\xe0\xf0@"},
+                {"EUC-JP", "a日本ｱ丂", "a\xc6\xfc\xcb\xdc\x8e\xb1\x8f\xb0\xa1"},
+        } {
+                checkRoundTrip(t, tc.name, tc.text, tc.enc)
+        }
+        // IBM扩展字符的另一编码只用于解码
+        if got := mustConvert(t, "CP932", "UTF-8", []byte("\xfa\x40\xfb\xfc")); string(got) != "ⅰ髙" {
+                t.Errorf("CP932 IBM extension: got %q", got)
+        }
+        for _, tc := range []struct{ text, enc string }{
+                {"abc", "abc"},
+                {"abc日本語def", "abc\x1b$BF|K\\8l\x1b(Bdef"},
+                {"日本", "\x1b$BF|K\\\x1b(B"},
+                {"亜\n亜", "\x1b$B0!\x1b(B\n\x1b$B0!\x1b(B"},
+        } {
+                checkRoundTrip(t, "ISO-2022-JP", tc.text, tc.enc)
+        }
+        // 解码时也接受ESC $ @(JIS C 6226-1978)及JIS X 0201罗马字
+        for _, tc := range []struct{ enc, text string }{
+                {"\x1b$@0!\x1b(B", "亜"},
+                {"a\x1b(J\\~b\x1b(B\\", "a¥‾b\\"},
+        } {
+                if got := mustConvert(t, "ISO-2022-JP", "UTF-8", []byte(tc.enc)); string(got) != tc.text {
+                        t.Errorf("%q: got %q, want %q", tc.enc, got, tc.text)
+                }
+                checkStreamSplit(t, "ISO-2022-JP", "UTF-8", []byte(tc.enc), tc.text)
+        }
+        // 半角片假名不在ISO-2022-JP中
+        if _, err := convertFromUTF8("ISO-2022-JP", []byte("ｱ")); err == nil {
+                t.Error("halfwidth katakana encoded")
+        }
+}
+
+// 韩文编码: CP949的统一韩文扩展区, EUC-KR只含KS X 1001, ISO-2022-KR的SO/SI切换
+func TestKorean(t *testing.T) {
+        for _, tc := range []struct{ name, text, enc string }{
+                {"EUC-KR", "a한국어", "a\xc7\xd1\xb1\xb9\xbe\xee"},
+                {"CP949", "한국어똠", "\xc7\xd1\xb1\xb9\xbe\xee\x8cc"},
+                {"ISO-2022-KR", "가나 다", "\x1b$)C\x0e0!3*\x0f \x0e4Y\x0f"},
+                {"ISO-2022-KR", "a\n가", "\x1b$)Ca\n\x0e0!\x0f"},
+        } {
+                checkRoundTrip(t, tc.name, tc.text, tc.enc)
+        }
+        // 首字节及尾字节的范围
+        for _, tc := range []struct{ name, enc string }{
+                {"EUC-KR", "\x8cc"},
+                {"CP949", "\x80a"},
+                {"CP949", "\x81\x5b"},
+                {"CP949", "\xc7\x7f"},
+                {"CP949", "\xff\xa1"},
+                {"ISO-2022-KR", "\x1b$)C\x0e0\x7f\x0f"},
+                {"ISO-2022-KR", "\x1b$)C\xb0\xa1"},
+        } {
+                c, err := NewCoderByName(tc.name, "UTF-8")
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if got, err := AppendConvert(c, nil, []byte(tc.enc)); err == nil {
+                        t.Errorf("%s %q: got %q, want error", tc.name, tc.enc, got)
+                }
+                c.Close()
+        }
+        if _, err := convertFromUTF8("EUC-KR", []byte("똠")); err == nil {
+                t.Error("EUC-KR encoded a UHC character")
+        }
+        // 流式编码时指定序列只在流的开头输出一次
+        c, err := NewCoderByName("UTF-8", "ISO-2022-KR", WithStream())
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        a, _ := AppendConvert(c, nil, []byte("가"))
+        if b, err := AppendConvert(c, a, []byte("가")); err != nil || string(b) != "\x1b$)C\x0e0!\x0f\x0e0!\x0f" {
+                t.Errorf("stream: got %q %v", b, err)
+        }
+}
+
+// EBCDIC编码: 单字节代码页, IBM-935/IBM-1388的SO/SI切换及与GBK之间的转换
+func TestEBCDIC(t *testing.T) {
+        for _, tc := range []struct{ name, text, enc string }{
+                {"CP037", "Hello[]", "\xc8\x85\x93\x93\x96\xba\xbb"},
+                {"CP500", "Hello[]", "\xc8\x85\x93\x93\x96JZ"},
+                {"CP1047", "Hello[]", "\xc8\x85\x93\x93\x96\xad\xbd"},
+                {"IBM-935", "AB中文C", "\xc1\xc2\x0e[\xcfW\xc3\x0f\xc3"},
+                {"IBM-935", "中文", "\x0e[\xcfW\xc3\x0f"},
+                {"IBM-1388", "x鍀丂", "\xa7\x0e\xbf\xcf\x81A\x0f"},
+        } {
+                checkRoundTrip(t, tc.name, tc.text, tc.enc)
+        }
+        gbk := mustConvert(t, "UTF-8", "GBK", []byte("AB中文C"))
+        e := mustConvert(t, "GBK", "IBM-935", gbk)
+        if string(e) != "\xc1\xc2\x0e[\xcfW\xc3\x0f\xc3" {
+                t.Errorf("GBK->IBM-935: got % x", e)
+        }
+        if got := mustConvert(t, "IBM-935", "GBK", e); !bytes.Equal(got, gbk) {
+                t.Errorf("IBM-935->GBK: got % x, want % x", got, gbk)
+        }
+        // 双字节状态下不完整的字符, 以及IBM-935(GB2312)中没有的GBK字符
+        c := mustCoder(t, IBM935_UTF8_IDX)
+        defer c.Close()
+        if _, err := c.Convert([]byte("\x0e[\xcf["), make([]byte, 64)); err == nil {
+                t.Error("IBM-935 accepted an incomplete double-byte character")
+        }
+        if _, err := convertFromUTF8("IBM-935", []byte("鍀")); err == nil {
+                t.Error("IBM-935 encoded a GBK-only character")
+        }
+}
+
+// HZ-GB-2312及ISO-2022-CN: 使用GB2312的部分, 行末复位到ASCII
+func TestHZISO2022CN(t *testing.T) {
+        for _, tc := range []struct{ name, text, enc string }{
+                {"HZ-GB-2312", "a中文~b", "a~{VPND~}~~b"},
+                {"HZ-GB-2312", "中\n中x", "~{VP~}\n~{VP~}x"},
+                {"ISO-2022-CN", "a中文~b", "a\x1b$)A\x0eVPND\x0f~b"},
+                {"ISO-2022-CN", "中\n中x", "\x1b$)A\x0eVP\x0f\n\x1b$)A\x0eVP\x0fx"},
+        } {
+                checkRoundTrip(t, tc.name, tc.text, tc.enc)
+        }
+        for _, tc := range []struct{ name, enc, text string }{
+                {"HZ-GB-2312", "~{VP\nab", "中\nab"},
+                {"HZ-GB-2312", "a~\nb", "ab"},
+                {"ISO-2022-CN", "\x1b$)A\x0eVP\nab", "中\nab"},
+        } {
+                if got := mustConvert(t, tc.name, "UTF-8", []byte(tc.enc)); string(got) != tc.text {
+                        t.Errorf("%s %q: got %q, want %q", tc.name, tc.enc, got, tc.text)
+                }
+                checkStreamSplit(t, tc.name, "UTF-8", []byte(tc.enc), tc.text)
+        }
+        for _, name := range []string{"HZ-GB-2312", "ISO-2022-CN"} {
+                if _, err := convertFromUTF8(name, []byte("鍀")); err == nil {
+                        t.Errorf("%s encoded a GBK-only character", name)
+                }
+        }
+}
+
+// 按BOM, 字节结构及常用汉字比例识别编码
+func TestDetect(t *testing.T) {
+        simp := []byte("中华人民共和国是一个国家，我们在这里学习和工作。")
+        trad := []byte("中華民國在臺灣，我們在這裡學習和工作。")
+        for _, tc := range []struct {
+                src     []byte
+                charset string
+                min     float64 // 置信度的下限
+        }{
+                {nil, "", 0},
+                {[]byte("hello"), "UTF-8", 0.9},
+                {simp, "UTF-8", 0.9},
+                {append([]byte("\xef\xbb\xbf"), simp...), "UTF-8", 1},
+                {mustConvert(t, "UTF-8", "GBK", simp), "GBK", 0.6},
+                {mustConvert(t, "UTF-8", "GBK", trad), "GBK", 0.6},
+                {mustConvert(t, "UTF-8", "GB18030", []byte("中文€À")), "GB18030", 0.5},
+                {mustConvert(t, "UTF-8", "BIG5", trad), "BIG5", 0.6},
+                {mustConvert(t, "UTF-8", "UTF-16LE", simp)[2:], "UTF-16LE", 0.5},
+                {mustConvert(t, "UTF-8", "UTF-16BE", simp)[2:], "UTF-16BE", 0.5},
+                {mustConvert(t, "UTF-8", "UTF-16LE", []byte("hello world"))[2:], "UTF-16LE", 0.9},
+                {mustConvert(t, "UTF-8", "UTF-16BE", simp), "UTF-16BE", 1},
+        } {
+                charset, conf := Detect(tc.src)
+                if charset != tc.charset || conf < tc.min || conf > 1 {
+                        t.Errorf("% .12x: got %s %.2f, want %s >= %.2f", tc.src, charset, conf, tc.charset, tc.min)
+                }
+        }
+}
+
+// NewCoderAuto在第一次转换时识别编码, 之后沿用, Flush后重新识别
+func TestNewCoderAuto(t *testing.T) {
+        trad := "中華民國在臺灣，我們在這裡學習和工作。"
+        simp := "中华人民共和国是一个国家，我们在这里学习和工作。"
+        c, err := NewCoderAuto("utf-8")
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        if c.Source() != "AUTO" || c.Target() != "UTF-8" {
+                t.Errorf("before detection: %s->%s", c.Source(), c.Target())
+        }
+        big5 := mustConvert(t, "UTF-8", "BIG5", []byte(trad))
+        out := make([]byte, c.MaxEncodedLen(len(big5)))
+        n, err := c.Convert(big5[:12], out)
+        if err != nil || c.Source() != "BIG5" {
+                t.Fatalf("first chunk: %s %v", c.Source(), err)
+        }
+        m, err := c.Convert(big5[12:], out[n:])
+        if err != nil || string(out[:n+m]) != trad {
+                t.Errorf("got %q %v", out[:n+m], err)
+        }
+        if err := c.Flush(); err != nil {
+                t.Fatal(err)
+        }
+        gbk := mustConvert(t, "UTF-8", "GBK", []byte(simp))
+        if got, err := AppendConvert(c, nil, gbk); err != nil || string(got) != simp || c.Source() != "GBK" {
+                t.Errorf("after Flush: %s %q %v", c.Source(), got, err)
+        }
+        if _, err := NewCoderAuto("NO-SUCH-CHARSET"); err == nil {
+                t.Error("NewCoderAuto accepted an unknown target")
+        }
+}
+
+// 校验函数返回第一个非法字符的偏移, 全部合法时返回-1
+func TestValid(t *testing.T) {
+        ascii := "0123456789abcdefghij"
+        for _, tc := range []struct {
+                src          string
+                gbk, gb18030 int
+        }{
+                {"", -1, -1},
+                {"abc", -1, -1},
+                {"a\xd6\xd0b\xa1\xa1", -1, -1},
+                {ascii + "\x80", 20, 20},
+                {ascii + "\xd6\xd0\xd6", 22, 22},
+                {"\xd6\x7f", 0, 0},
+                {"\xd6\xff", 0, 0},
+                {"\xff\x40", 0, 0},
+                {"\xd6\x20", 0, 0},
+                {"a\x81\x30\x81\x30", 1, -1},
+                {"ab\x81\x30\x81", 2, 2},
+                {"\x81\x30\xff\x30", 0, 0},
+                {"\x81\x30\x81\x3a", 0, 0},
+                {"\x84\x31\xa4\x39\xd6\xd0" + ascii + "\xfe", 0, 26},
+        } {
+                if got := ValidGBK([]byte(tc.src)); got != tc.gbk {
+                        t.Errorf("ValidGBK(%q) = %d, want %d", tc.src, got, tc.gbk)
+                }
+                if got := ValidGB18030([]byte(tc.src)); got != tc.gb18030 {
+                        t.Errorf("ValidGB18030(%q) = %d, want %d", tc.src, got, tc.gb18030)
+                }
+        }
+        // 测试数据为小端序, 大端序的数据由每两字节交换得到
+        for _, tc := range []struct {
+                src string
+                off int
+        }{
+                {"", -1},
+                {"a\x00", -1},
+                {"a\x00b", 2},
+                {"a\x00b\x00c\x00d\x00e\x00f\x00g\x00h\x00\x00\xd8", 16},
+                {"\x00\xd8\x00\xdc", -1},
+                {"a\x00b\x00c\x00\x3d\xd8\x00\xdea\x00", -1},
+                {"\x00\xd8a\x00", 0},
+                {"\x00\xdc\x00\xd8", 0},
+                {"a\x00b\x00c\x00\x00\xdc", 6},
+                {"a\x00\xff\xdb\xff\xdf\x00\xd8", 6},
+        } {
+                le := []byte(tc.src)
+                be := make([]byte, len(le))
+                for i := 0; i+1 < len(le); i += 2 {
+                        be[i], be[i+1] = le[i+1], le[i]
+                }
+                if len(le)%2 == 1 {
+                        be[len(be)-1] = le[len(le)-1]
+                }
+                if got := ValidUTF16LE(le); got != tc.off {
+                        t.Errorf("ValidUTF16LE(% x) = %d, want %d", le, got, tc.off)
+                }
+                if got := ValidUTF16BE(be); got != tc.off {
+                        t.Errorf("ValidUTF16BE(% x) = %d, want %d", be, got, tc.off)
+                }
+                // BOM按普通字符校验, 偏移包括BOM
+                want := tc.off
+                if want >= 0 {
+                        want += 2
+                }
+                if got := ValidUTF16(append([]byte{0xfe, 0xff}, be...)); got != want {
+                        t.Errorf("ValidUTF16(BOM % x) = %d, want %d", be, got, want)
+                }
+        }
+}
+
+// 自定义映射优先于内置映射表, 冲突的映射在创建转换器时返回错误
+func TestOverrides(t *testing.T) {
+        // 交换0xAAA1(内置映射为U+E000)与U+F8F0(内置映射为0x8339FD35)
+        swap := map[uint64]uint64{0xaaa1: 0xf8f0, 0x8339fd35: 0xe000}
+        for _, tc := range []struct {
+                from, to string
+                opts     []Option
+        }{
+                {"GBK", "UTF-8", []Option{WithOverrides("GBK", map[uint64]uint64{0xaaa1: 0x4e00})}},
+                {"GBK", "UTF-8", []Option{WithOverrides("GBK", map[uint64]uint64{0xaaa1: 0xf8f0})}},
+                {"GBK", "UTF-8", []Option{WithOverrides("GBK", map[uint64]uint64{0xaaa1: 0xe000, 0xaaa2: 0xe000})}},
+                {"GBK", "UTF-8", []Option{WithOverrides("GBK", swap), WithOverrides("GB18030", swap)}},
+                {"GBK", "UTF-8", []Option{WithOverrides("BIG5", swap)}},
+                {"GBK", "UTF-8", []Option{WithOverrides("UTF-8", swap)}},
+                {"GBK", "UTF-8", []Option{WithOverrides("NO-SUCH-CHARSET", swap)}},
+        } {
+                if c, err := NewCoderByName(tc.from, tc.to, tc.opts...); err == nil {
+                        c.Close()
+                        t.Errorf("%s->%s: conflicting overrides accepted", tc.from, tc.to)
+                }
+        }
+        // GB18030与GBK共用映射表, 覆盖对两者都生效
+        if got := mustConvert(t, "UTF-8", "GBK", []byte("\uf8f0\ue000")); string(got) != "\x83\x39\xfd\x35\xaa\xa1" {
+                t.Fatalf("built-in mapping: got % x", got)
+        }
+        for _, tc := range []struct{ from, to, src, want string }{
+                {"GB18030", "UTF-8", "\xaa\xa1a\x83\x39\xfd\x35", "\uf8f0a\ue000"},
+                {"UTF-8", "GBK", "\uf8f0\ue000", "\xaa\xa1\x83\x39\xfd\x35"},
+        } {
+                c, err := NewCoderByName(tc.from, tc.to, WithOverrides("GBK", swap))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                if got, err := AppendConvert(c, nil, []byte(tc.src)); err != nil || string(got) != tc.want {
+                        t.Errorf("%s->%s %q: got %q %v, want %q", tc.from, tc.to, tc.src, got, err, tc.want)
+                }
+                c.Close()
+        }
+        m, err := ReadOverrides(strings.NewReader("# vendor\n0xAAA1 0xF8F0\n\n0x8339FD35\t0xE000 # swap\n"))
+        if err != nil || len(m) != 2 || m[0xaaa1] != 0xf8f0 || m[0x8339fd35] != 0xe000 {
+                t.Errorf("ReadOverrides: %v %v", m, err)
+        }
+        for _, src := range []string{"0xAAA1\n", "0xAAA1 0xF8F0 0x1\n", "0xAAA1 zz\n", "0xAAA1 0xF8F0\n0xAAA1 0xF8F1\n"} {
+                if _, err := ReadOverrides(strings.NewReader(src)); err == nil {
+                        t.Errorf("ReadOverrides(%q) accepted", src)
+                }
+        }
+}
+
+// 各私用区策略在解码和编码两个方向的结果, want为空时应返回错误
+func TestPUAPolicy(t *testing.T) {
+        for _, tc := range []struct {
+                policy    PUAPolicy
+                from, to  string
+                src, want string
+        }{
+                {PUATable, "GBK", "UTF-8", "\xaa\xa1\xa7\xa0\xa2\xab", "\ue000\ue765\ue766"},
+                {PUATable, "GB18030", "UTF-8", "\xa6\xd9\x84\x31\x82\x36", "\ue78d\ufe10"},
+                {PUATable, "UTF-8", "GB18030", "\ufe10\ue78d", "\x84\x31\x82\x36\xa6\xd9"},
+                {PUACP936, "GBK", "UTF-8", "\xaa\xa1\xa7\xa0\xa2\xab", "\ue000\ue765\ue766"},
+                {PUACP936, "UTF-8", "GBK", "\ue000\ue765\ue766", "\xaa\xa1\xa7\xa0\xa2\xab"},
+                {PUACP936, "UTF-8", "GB18030", "\ue865", ""},
+                {PUAGB18030_2022, "GB18030", "UTF-8", "\xa6\xd9\xfe\x59\x84\x31\x82\x36", "\ufe10\u9fb4\ue78d"},
+                {PUAGB18030_2022, "UTF-8", "GB18030", "\ufe10\u9fb4\ue78d", "\xa6\xd9\xfe\x59\x84\x31\x82\x36"},
+                {PUAReject, "GBK", "UTF-8", "a\xaa\xa1", ""},
+                {PUAReject, "UTF-8", "UTF-16LE", "\ue000", ""},
+                {PUAReject, "GBK", "UTF-8", "a\xa1\xa1", "a\u3000"},
+                {PUASubstitute, "GBK", "UTF-8", "a\xaa\xa1", "a\ufffd"},
+                {PUASubstitute, "UTF-8", "GBK", "a\ue000", "a?"},
+                {PUASubstitute, "UTF-8", "UTF-16BE", "\U000f0000", "\xfe\xff\xff\xfd"},
+        } {
+                c, err := NewCoderByName(tc.from, tc.to, WithPUAPolicy(tc.policy))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                got, err := AppendConvert(c, nil, []byte(tc.src))
+                if tc.want == "" && err == nil || tc.want != "" && (err != nil || string(got) != tc.want) {
+                        t.Errorf("%v %s->%s %q: got %q %v, want %q", tc.policy, tc.from, tc.to, tc.src, got, err, tc.want)
+                }
+                c.Close()
+        }
+        // 按GB18030及CP936修改映射表的策略只适用于GBK系列编码
+        for _, p := range []PUAPolicy{PUACP936, PUAGB18030_2022} {
+                if c, err := NewCoderByName("BIG5", "UTF-8", WithPUAPolicy(p)); err == nil {
+                        c.Close()
+                        t.Errorf("%v accepted for BIG5", p)
+                }
+        }
+}
+
+// NFC只做规范合成, NFKC还替换兼容字符; want为空时应返回错误
+func TestNormalization(t *testing.T) {
+        for _, tc := range []struct {
+                form      NormForm
+                to        string
+                src, want string
+        }{
+                {NormNone, "ISO-8859-1", "cafe\u0301", ""},
+                {NFC, "ISO-8859-1", "cafe\u0301", "caf\xe9"},
+                {NFC, "GBK", "cafe\u0301", "caf\xa8\xa6"},
+                {NFKC, "GBK", "cafe\u0301", "caf\xa8\xa6"},
+                {NFC, "GB18030", "a\u0300e\u0301", "\xa8\xa4\xa8\xa6"},
+                {NFC, "GBK", "①", "\xa2\xd9"},
+                {NFKC, "GBK", "①", "1"},
+                {NFC, "UTF-8", "ＡＢ①ﬁ", "ＡＢ①ﬁ"},
+                {NFKC, "UTF-8", "ＡＢ①ﬁ", "AB1fi"},
+                {NFC, "UTF-8", "\u212b", "\u00c5"},
+                {NFC, "UTF-8", "e\u0323\u0302", "\u1ec7"},
+                {NFC, "UTF-8", "e\u0302\u0323", "\u1ec7"},
+                {NFC, "UTF-8", "\u1100\u1161\u11a8", "\uac01"},
+                {NFC, "UTF-8", "\u0958", "\u0915\u093c"},
+                {NFKC, "UTF-8", "\u3392", "MHz"},
+        } {
+                c, err := NewCoderByName("UTF-8", tc.to, WithNormalization(tc.form))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                got, err := AppendConvert(c, nil, []byte(tc.src))
+                if tc.want == "" && err == nil || tc.want != "" && (err != nil || string(got) != tc.want) {
+                        t.Errorf("%v UTF-8->%s %q: got %q %v, want %q", tc.form, tc.to, tc.src, got, err, tc.want)
+                }
+                c.Close()
+        }
+}
+
+// 全角/半角转换在Unicode中转时进行, 对各源编码及目标编码的结果相同
+func TestWidthFold(t *testing.T) {
+        for _, tc := range []struct {
+                form       WidthForm
+                alnumOnly  bool
+                from, to   string
+                text, want string // 均为UTF-8, 转换前后分别转为from及to编码
+        }{
+                {WidthNone, false, "UTF-8", "UTF-8", "Ａｂ３，　中", "Ａｂ３，　中"},
+                {WidthHalf, false, "UTF-8", "UTF-8", "Ａｂ３，　中～", "Ab3, 中~"},
+                {WidthHalf, true, "UTF-8", "UTF-8", "Ａｂ３，　中", "Ab3，　中"},
+                {WidthHalf, false, "GBK", "UTF-8", "Ａｂ３，　中", "Ab3, 中"},
+                {WidthHalf, false, "GBK", "UTF-16LE", "Ａｂ３，　中", "Ab3, 中"},
+                {WidthHalf, false, "UTF-16BE", "GBK", "Ａｂ３，　中", "Ab3, 中"},
+                {WidthFull, false, "UTF-8", "GBK", "a1 !中", "ａ１　！中"},
+                {WidthFull, true, "UTF-8", "GBK", "a1 !中", "ａ１ !中"},
+                {WidthFull, false, "BIG5", "UTF-16BE", "a1 中", "ａ１　中"},
+        } {
+                c, err := NewCoderByName(tc.from, tc.to, WithWidthFold(tc.form, tc.alnumOnly))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                src := mustConvert(t, "UTF-8", tc.from, []byte(tc.text))
+                want := mustConvert(t, "UTF-8", tc.to, []byte(tc.want))
+                if got, err := AppendConvert(c, nil, src); err != nil || !bytes.Equal(got, want) {
+                        t.Errorf("%v %v %s->%s %q: got % x %v, want % x", tc.form, tc.alnumOnly, tc.from, tc.to, tc.text, got, err, want)
+                }
+                c.Close()
+        }
+        // 被转换的字符报告为有损
+        var lost []int
+        c, err := NewCoderByName("UTF-8", "GBK", WithWidthFold(WidthFull, true), WithLossReport(func(off int, code uint64) { lost = append(lost, off) }))
+        if err != nil {
+                t.Fatal(err)
+        }
+        defer c.Close()
+        if _, err := AppendConvert(c, nil, []byte("a-1")); err != nil || len(lost) != 2 || lost[0] != 0 || lost[1] != 2 {
+                t.Errorf("loss report: %v %v", lost, err)
+        }
+}
+
+func mustChineseDict(t *testing.T, s string) *ChineseDict {
+        d, err := ReadChineseDict(strings.NewReader(s))
+        if err != nil {
+                t.Fatal(err)
+        }
+        return d
+}
+
+// 简繁转换: 组内取最长匹配, 各组依次作用, 作为转换器的处理阶段一次完成编码转换
+func TestChineseConversion(t *testing.T) {
+        phrases := mustChineseDict(t, "# 词组\n头发\t頭髮\n")
+        chars := mustChineseDict(t, "汉\t漢\n语\t語\n头\t頭\n发\t發 髮\n里\t裏 裡\n")
+        tw := mustChineseDict(t, "裏\t裡\n")
+        s2t := NewChineseConverter([]*ChineseDict{phrases, chars})
+        s2tw := NewChineseConverter([]*ChineseDict{phrases, chars}, []*ChineseDict{tw})
+        for _, tc := range []struct {
+                cc       *ChineseConverter
+                src, dst string
+        }{
+                {s2t, "汉语头发里发", "漢語頭髮裏發"},
+                {s2tw, "汉语头发里发", "漢語頭髮裡發"},
+                {s2tw, "abc", "abc"},
+                {NewChineseConverter([]*ChineseDict{tw.Reverse()}), "頭髮裡", "頭髮裏"},
+        } {
+                if got := tc.cc.Convert(tc.src); got != tc.dst {
+                        t.Errorf("Convert(%q) = %q, want %q", tc.src, got, tc.dst)
+                }
+        }
+        gbk := mustConvert(t, "UTF-8", "GBK", []byte("a汉语头发里"))
+        for _, to := range []string{"UTF-8", "BIG5"} {
+                c, err := NewCoderByName("GBK", to, WithChineseConversion(s2tw))
+                if err != nil {
+                        t.Fatal(err)
+                }
+                want := mustConvert(t, "UTF-8", to, []byte("a漢語頭髮裡"))
+                if got, err := AppendConvert(c, nil, gbk); err != nil || !bytes.Equal(got, want) {
+                        t.Errorf("GBK->%s: got % x %v, want % x", to, got, err, want)
+                }
+                c.Close()
+        }
+        // 没有字典时返回错误, 不会不经转换直接输出
+        empty := mustChineseDict(t, "")
+        for k, cc := range []*ChineseConverter{
+                nil,
+                NewChineseConverter(),
+                NewChineseConverter([]*ChineseDict{}),
+                NewChineseConverter([]*ChineseDict{empty}),
+                NewChineseConverter([]*ChineseDict{chars}, []*ChineseDict{nil}),
+        } {
+                if c, err := NewCoderByName("GBK", "UTF-8", WithChineseConversion(cc)); err == nil {
+                        c.Close()
+                        t.Errorf("case %d: converter without dictionaries accepted", k)
+                }
+        }
+        if _, err := ReadChineseDict(strings.NewReader("汉漢\n")); err == nil {
+                t.Error("ReadChineseDict accepted a line without a tab")
+        }
+        for _, config := range []string{"s2t", "x2y"} {
+                if _, err := LoadChineseConverter("no-such-dir", config); err == nil {
+                        t.Errorf("LoadChineseConverter(%q) without dictionaries succeeded", config)
+                }
+        }
+}
+
+// 由若干字符串循环拼接为不小于n字节的文本
+func repeatText(n int, words ...string) []byte {
+        var buf bytes.Buffer
+        for k := 0; buf.Len() < n; k++ {
+                buf.WriteString(words[k*7%len(words)])
+        }
+        return buf.Bytes()
+}
+
+// 并行转换的结果与顺序的流式转换相同; 多字节字符跨分段及段内分块的边界
+func TestConvertParallel(t *testing.T) {
+        sequential := func(from, to string, src []byte) ([]byte, error) {
+                c, err := NewCoderByName(from, to, WithStream())
+                if err != nil {
+                        t.Fatal(err)
+                }
+                defer c.Close()
+                out, err := AppendConvert(c, nil, src)
+                if err != nil {
+                        return out, err
+                }
+                return out, c.Flush()
+        }
+        // UTF-8没有小于0x30的字节时在字符开头分段, GBK等在这些字节之后分段
+        noSep := repeatText(3<<20+5, "中文", "测试", "é", "€", "汉字转换")
+        withSep := repeatText(3<<20+5, "中文测试丂亐", "汉字转换", "é", "abc", "，", "\n", "@")
+        gb18030, err := sequential("UTF-8", "GB18030", noSep)
+        if err != nil {
+                t.Fatal(err)
+        }
+        gbk, err := sequential("UTF-8", "GBK", withSep)
+        if err != nil {
+                t.Fatal(err)
+        }
+        big5, err := sequential("UTF-8", "BIG5", repeatText(3<<20+5, "中文測試", "漢字轉換", " ", "臺灣"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        // 含代理对
+        utf16, err := sequential("UTF-8", "UTF-16LE", repeatText(3<<20+5, "中文", "😀", "é", "𧉧"))
+        if err != nil {
+                t.Fatal(err)
+        }
+        for _, tc := range []struct {
+                from, to string
+                src      []byte
+        }{
+                {"UTF-8", "GB18030", noSep},
+                {"UTF-8", "UTF-16BE", noSep},
+                {"UTF-8", "GBK", withSep},
+                {"GB18030", "UTF-8", gb18030},
+                {"GBK", "UTF-8", gbk},
+                {"GBK", "UTF-16LE", gbk},
+                {"BIG5", "UTF-8", big5},
+                {"UTF-16LE", "UTF-8", utf16},
+                {"UTF-16", "UTF-32BE", utf16},
+        } {
+                want, err := sequential(tc.from, tc.to, tc.src)
+                if err != nil {
+                        t.Fatalf("%s->%s: %v", tc.from, tc.to, err)
+                }
+                for _, workers := range []int{1, 2, 3, 8} {
+                        got, err := ConvertParallel(tc.from, tc.to, tc.src, workers)
+                        if err != nil || !bytes.Equal(got, want) {
+                                t.Errorf("%s->%s workers=%d: %v, %d bytes, want %d", tc.from, tc.to, workers, err, len(got), len(want))
+                        }
+                }
+        }
+        // 出错位置为在整个输入中的偏移, 返回出错位置之前的结果
+        bad := append([]byte(nil), gbk...)
+        pos := 2<<20 + 1
+        for bad[pos-1] >= 0x80 {
+                pos++
+        }
+        bad[pos] = 0xff
+        want, werr := sequential("GBK", "UTF-8", bad)
+        var ce, wce *ConvertError
+        got, err := ConvertParallel("GBK", "UTF-8", bad, 4)
+        if !errors.As(err, &ce) || !errors.As(werr, &wce) || ce.Offset != pos || wce.Offset != pos ||
+                ce.Written != len(got) || !bytes.Equal(got, want[:wce.Written]) {
+                t.Errorf("error: %v, sequential %v, want offset %d", err, werr, pos)
+        }
+}
+
+// 映射表被引用的次数, 不在缓存中时为0
+func tableRefs(file string) int {
+        g_TablesLock.Lock()
+        defer g_TablesLock.Unlock()
+        if lt, ok := g_Tables[file]; ok {
+                return lt.refs
+        }
+        return 0
+}
+
+// 只在调用期间使用映射表的函数须释放引用, 创建失败的转换器不保留引用
+func TestTableRefs(t *testing.T) {
+        big5 := mustConvert(t, "UTF-8", "BIG5", []byte("中文測試, 這是一段繁體中文的文字"))
+        dec, enc := tableRefs("Big52Unicode.db"), tableRefs("Unicode2Big5.db")
+        if dec == 0 {
+                // 没有转换器引用时, 用完的映射表从缓存中删除
+                Detect(big5)
+                g_TablesLock.Lock()
+                _, cached := g_Tables["Big52Unicode.db"]
+                g_TablesLock.Unlock()
+                if cached {
+                        t.Error("Big52Unicode.db still cached after Detect")
+                }
+        }
+        c, err := NewCoderByName("BIG5", "UTF-8")
+        if err != nil {
+                t.Fatal(err)
+        }
+        if n := tableRefs("Big52Unicode.db"); n != dec+1 {
+                t.Fatalf("open converter: refs=%d, want %d", n, dec+1)
+        }
+        Detect(big5)
+        if _, _, err := CheckTable("BIG5"); err != nil {
+                t.Fatal(err)
+        }
+        // 两个编码对应同一码点, 在加载映射表之后才检查出错误
+        _, err = NewCoderByName("BIG5", "UTF-8", WithOverrides("BIG5", map[uint64]uint64{0xa140: 0x41, 0xa141: 0x41}))
+        if err == nil {
+                t.Fatal("conflicting overrides accepted")
+        }
+        if n := tableRefs("Big52Unicode.db"); n != dec+1 {
+                t.Errorf("after Detect, CheckTable and failed NewCoderByName: refs=%d, want %d", n, dec+1)
+        }
+        if n := tableRefs("Unicode2Big5.db"); n != enc {
+                t.Errorf("Unicode2Big5.db refs=%d, want %d", n, enc)
+        }
+        c.Close()
+        if n := tableRefs("Big52Unicode.db"); n != dec {
+                t.Errorf("after Close: refs=%d, want %d", n, dec)
+        }
+}
+
+// 基准测试的输入语料, 约1MB的UTF-8文本
+func benchCorpus(kind string) []byte {
+        var unit string
+        switch kind {
+        case "ASCII":
+                unit = "The quick brown fox jumps over the lazy dog. 0123456789\n"
+        case "JSON":
+                // 以ASCII为主, 夹杂少量汉字
+                unit = `{"id": 1024, "url": "https://example.com/api/v1/users?page=2", "tags": ["a", "b"], "name": "张三"}` + "\n"
+        case "CJK":
+                // 以汉字为主
+                unit = "中华人民共和国成立于一九四九年, 首都是北京. 长江与黄河是中国最长的两条河流.\n"
+        }
+        return bytes.Repeat([]byte(unit), (1<<20)/len(unit))
+}
+
+func benchmarkConvert(b *testing.B, idx CODING_IDX, kind string) {
+        c := mustCoder(b, idx)
+        defer c.Close()
+        src := benchCorpus(kind)
+        if c.Source() != "UTF-8" {
+                src = mustConvert(b, "UTF-8", c.Source(), src)
+        }
+        out := make([]byte, c.MaxEncodedLen(len(src)))
+        b.SetBytes(int64(len(src)))
+        b.ResetTimer()
+        for k := 0; k < b.N; k++ {
+                if _, err := c.Convert(src, out); err != nil {
+                        b.Fatal(err)
+                }
+        }
+}
+
+func BenchmarkGBKToUTF8ASCII(b *testing.B)    { benchmarkConvert(b, GBK_UTF8_IDX, "ASCII") }
+func BenchmarkGBKToUTF8JSON(b *testing.B)     { benchmarkConvert(b, GBK_UTF8_IDX, "JSON") }
+func BenchmarkGBKToUTF8CJK(b *testing.B)      { benchmarkConvert(b, GBK_UTF8_IDX, "CJK") }
+func BenchmarkUTF8ToGBKASCII(b *testing.B)    { benchmarkConvert(b, UTF8_GBK_IDX, "ASCII") }
+func BenchmarkUTF8ToGBKJSON(b *testing.B)     { benchmarkConvert(b, UTF8_GBK_IDX, "JSON") }
+func BenchmarkUTF8ToGBKCJK(b *testing.B)      { benchmarkConvert(b, UTF8_GBK_IDX, "CJK") }
+func BenchmarkUTF8ToUTF16LEJSON(b *testing.B) { benchmarkConvert(b, UTF8_UTF16_LE_IDX, "JSON") }
+func BenchmarkUTF8ToUTF16LECJK(b *testing.B)  { benchmarkConvert(b, UTF8_UTF16_LE_IDX, "CJK") }
+func BenchmarkUTF8ToUTF16BECJK(b *testing.B)  { benchmarkConvert(b, UTF8_UTF16_BE_IDX, "CJK") }
@@ -0,0 +1,189 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+)
+
+// 日文编码: Shift_JIS, CP932, EUC-JP(含JIS X 0212)及有状态的ISO-2022-JP. 映射表按WHATWG的
+// index-jis0208.txt生成, 均含NEC/IBM扩展字符; CP932另含用户定义区0xF040~0xF9FC(映射到PUA).
+// ISO-2022-JP与EUC-JP共用映射表, JIS X 0208字符的EUC-JP编码为其JIS编码两字节各加0x80.
+
+// ISO-2022-JP的移位状态
+const (
+        jisASCII = iota // ESC ( B
+        jisRoman        // ESC ( J, JIS X 0201罗马字
+        jis0208         // ESC $ @ 或 ESC $ B, JIS X 0208
+)
+
+// 将值按大端序写入to, 按值的大小占1~4字节
+func putMBCS(v uint64, to []byte) (int, error) {
+        n := 4
+        switch {
+        case v < 0x100:
+                n = 1
+        case v < 0x10000:
+                n = 2
+        case v < 0x1000000:
+                n = 3
+        }
+        if len(to) < n {
+                return 0, errShortBuf
+        }
+        for k := n - 1; k >= 0; k-- {
+                to[k] = byte(v)
+                v >>= 8
+        }
+        return n, nil
+}
+
+func encodeMBCS(st *codeState, code uint64, to []byte) (int, error) {
+        if v, ok := st.encTbl[code]; ok {
+                return putMBCS(v, to)
+        }
+        return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+}
+
+// Shift_JIS/CP932: 首字节0x81~0x9F或0xE0~0xFC, 次字节0x40~0x7E或0x80~0xFC;
+// 其余为单字节(ASCII及半角片假名0xA1~0xDF)
+func decodeSJIS(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        if b < 0x80 {
+                return uint64(b), 1, nil
+        }
+        if b < 0x81 || (b > 0x9f && b < 0xe0) || b > 0xfc {
+                if v, ok := st.decTbl[uint64(b)]; ok {
+                        return v, 1, nil
+                }
+                return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", b)
+        }
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        if from[1] < 0x40 || from[1] == 0x7f || from[1] > 0xfc {
+                return 0, 0, errors.New("非法Shift_JIS编码")
+        }
+        tmpSjis := uint64(b)<<8 | uint64(from[1])
+        if v, ok := st.decTbl[tmpSjis]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpSjis)
+}
+
+// EUC-JP: 0x8E引导半角片假名, 0x8F引导三字节的JIS X 0212, 0xA1~0xFE为JIS X 0208
+func decodeEUCJP(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        n := 2
+        switch {
+        case b < 0x80:
+                return uint64(b), 1, nil
+        case b == 0x8f:
+                n = 3
+        case b != 0x8e && (b < 0xa1 || b == 0xff):
+                return 0, 0, errors.New("非法EUC-JP编码")
+        }
+        if len(from) < n {
+                return 0, 0, errShortSrc
+        }
+        tmpEuc := uint64(b)
+        for k := 1; k < n; k++ {
+                if from[k] < 0xa1 || from[k] == 0xff {
+                        return 0, 0, errors.New("非法EUC-JP编码")
+                }
+                tmpEuc = tmpEuc<<8 | uint64(from[k])
+        }
+        if v, ok := st.decTbl[tmpEuc]; ok {
+                return v, n, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpEuc)
+}
+
+// ISO-2022-JP(RFC 1468): 以转义序列切换字符集, 移位状态在多次调用之间保持
+func decodeISO2022JP(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        if b == 0x1b {
+                if len(from) < 3 {
+                        return 0, 0, errShortSrc
+                }
+                switch string(from[1:3]) {
+                case "(B":
+                        st.decMode = jisASCII
+                case "(J":
+                        st.decMode = jisRoman
+                case "$@", "$B":
+                        st.decMode = jis0208
+                default:
+                        return 0, 0, fmt.Errorf("不支持的ISO-2022-JP转义序列[%q]", from[:3])
+                }
+                return noCode, 3, nil
+        }
+        if b >= 0x80 {
+                return 0, 0, errors.New("非法ISO-2022-JP编码")
+        }
+        switch {
+        case st.decMode == jis0208 && b > 0x20 && b < 0x7f:
+                if len(from) < 2 {
+                        return 0, 0, errShortSrc
+                }
+                if from[1] < 0x21 || from[1] > 0x7e {
+                        return 0, 0, errors.New("非法ISO-2022-JP编码")
+                }
+                tmpJis := uint64(b|0x80)<<8 | uint64(from[1]|0x80)
+                if v, ok := st.decTbl[tmpJis]; ok {
+                        return v, 2, nil
+                }
+                return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpJis&0x7f7f)
+        case st.decMode == jisRoman && b == 0x5c:
+                return 0xa5, 1, nil
+        case st.decMode == jisRoman && b == 0x7e:
+                return 0x203e, 1, nil
+        }
+        return uint64(b), 1, nil
+}
+
+func encodeISO2022JP(st *codeState, code uint64, to []byte) (int, error) {
+        j := 0
+        if code < 0x80 {
+                if st.encMode != jisASCII {
+                        if len(to) < 3 {
+                                return 0, errShortBuf
+                        }
+                        j += copy(to, "\x1b(B")
+                        st.encMode = jisASCII
+                }
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = byte(code)
+                return j + 1, nil
+        }
+        v, ok := st.encTbl[code]
+        if !ok || v < 0xa1a1 || v > 0xfefe || v&0xff < 0xa1 {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        if st.encMode != jis0208 {
+                if len(to) < 3 {
+                        return 0, errShortBuf
+                }
+                j += copy(to, "\x1b$B")
+                st.encMode = jis0208
+        }
+        if len(to) < j+2 {
+                return 0, errShortBuf
+        }
+        to[j] = byte(v>>8) & 0x7f
+        to[j+1] = byte(v) & 0x7f
+        return j + 2, nil
+}
+
+// 输出结束时切换回ASCII
+func resetISO2022JP(st *codeState, to []byte) (int, error) {
+        if st.encMode == jisASCII {
+                return 0, nil
+        }
+        if len(to) < 3 {
+                return 0, errShortBuf
+        }
+        st.encMode = jisASCII
+        return copy(to, "\x1b(B"), nil
+}
@@ -0,0 +1,154 @@
+package better
+
+import (
+        "errors"
+        "fmt"
+)
+
+// 韩文编码: EUC-KR, CP949(UHC)及ISO-2022-KR, 三者共用CP949的映射表.
+// CP949在EUC-KR(KS X 1001)的基础上以0x81~0xC6为首字节扩展了其余8822个现代韩文音节.
+
+// ISO-2022-KR的移位状态
+const (
+        ksNoHeader = iota // 尚未输出 ESC $ ) C
+        ksASCII           // SI
+        ksX1001           // SO
+)
+
+// CP949: 首字节0x81~0xFE, 次字节0x41~0x5A, 0x61~0x7A或0x81~0xFE
+func decodeCP949(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        if b < 0x80 {
+                return uint64(b), 1, nil
+        }
+        if b == 0x80 || b == 0xff {
+                return 0, 0, errors.New("非法CP949编码")
+        }
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        t := from[1]
+        if t < 0x41 || (t > 0x5a && t < 0x61) || (t > 0x7a && t < 0x81) || t == 0xff {
+                return 0, 0, errors.New("非法CP949编码")
+        }
+        tmpUhc := uint64(b)<<8 | uint64(t)
+        if v, ok := st.decTbl[tmpUhc]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpUhc)
+}
+
+// EUC-KR: 首字节及次字节均为0xA1~0xFE
+func decodeEUCKR(st *codeState, from []byte) (uint64, int, error) {
+        if from[0] >= 0x80 && (from[0] < 0xa1 || from[0] == 0xff) {
+                return 0, 0, errors.New("非法EUC-KR编码")
+        }
+        if len(from) >= 2 && from[0] >= 0x80 && (from[1] < 0xa1 || from[1] == 0xff) {
+                return 0, 0, errors.New("非法EUC-KR编码")
+        }
+        return decodeCP949(st, from)
+}
+
+func encodeEUCKR(st *codeState, code uint64, to []byte) (int, error) {
+        v, ok := st.encTbl[code]
+        if !ok || (v >= 0x80 && (v < 0xa1a1 || v&0xff < 0xa1)) {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        return putMBCS(v, to)
+}
+
+// ISO-2022-KR(RFC 1557): ESC $ ) C指定KS X 1001, SO/SI切换, 行末回到ASCII
+func decodeISO2022KR(st *codeState, from []byte) (uint64, int, error) {
+        b := from[0]
+        switch {
+        case b == 0x1b:
+                if len(from) < 4 {
+                        return 0, 0, errShortSrc
+                }
+                if string(from[1:4]) != "$)C" {
+                        return 0, 0, fmt.Errorf("不支持的ISO-2022-KR转义序列[%q]", from[:4])
+                }
+                return noCode, 4, nil
+        case b == 0x0e:
+                st.decMode = ksX1001
+                return noCode, 1, nil
+        case b == 0x0f:
+                st.decMode = ksASCII
+                return noCode, 1, nil
+        case b >= 0x80:
+                return 0, 0, errors.New("非法ISO-2022-KR编码")
+        case b == '\n':
+                st.decMode = ksASCII
+        }
+        if st.decMode != ksX1001 || b < 0x21 || b > 0x7e {
+                return uint64(b), 1, nil
+        }
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        if from[1] < 0x21 || from[1] > 0x7e {
+                return 0, 0, errors.New("非法ISO-2022-KR编码")
+        }
+        tmpKs := uint64(b|0x80)<<8 | uint64(from[1]|0x80)
+        if v, ok := st.decTbl[tmpKs]; ok {
+                return v, 2, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpKs&0x7f7f)
+}
+
+func encodeISO2022KR(st *codeState, code uint64, to []byte) (int, error) {
+        j := 0
+        if st.encMode == ksNoHeader {
+                if len(to) < 4 {
+                        return 0, errShortBuf
+                }
+                j += copy(to, "\x1b$)C")
+                st.encMode = ksASCII
+        }
+        if code < 0x80 {
+                if st.encMode == ksX1001 {
+                        if len(to) < j+1 {
+                                return 0, errShortBuf
+                        }
+                        to[j] = 0x0f
+                        j++
+                        st.encMode = ksASCII
+                }
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = byte(code)
+                return j + 1, nil
+        }
+        v, ok := st.encTbl[code]
+        if !ok || v < 0xa1a1 || v&0xff < 0xa1 {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        if st.encMode != ksX1001 {
+                if len(to) < j+1 {
+                        return 0, errShortBuf
+                }
+                to[j] = 0x0e
+                j++
+                st.encMode = ksX1001
+        }
+        if len(to) < j+2 {
+                return 0, errShortBuf
+        }
+        to[j] = byte(v>>8) & 0x7f
+        to[j+1] = byte(v) & 0x7f
+        return j + 2, nil
+}
+
+// 输出结束时以SI切换回ASCII, 已输出的ESC $ ) C在整个流中保持有效
+func resetISO2022KR(st *codeState, to []byte) (int, error) {
+        if st.encMode != ksX1001 {
+                return 0, nil
+        }
+        if len(to) < 1 {
+                return 0, errShortBuf
+        }
+        to[0] = 0x0f
+        st.encMode = ksASCII
+        return 1, nil
+}
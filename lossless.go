@@ -0,0 +1,113 @@
+package better
+
+import (
+        "fmt"
+        "sort"
+)
+
+// 无损转换检查: 映射表并非一一对应时(如多个编码映射到同一Unicode码点),
+// 转换结果再反向转换将得不到原来的字节.
+
+type lossEntry struct {
+        off  int
+        code uint64
+}
+
+// 报告无法无损往返的字符. 每转换一个字符, 检查源字节能否由该码点重新编码得到,
+// 以及输出字节能否解码回该码点, 任一不成立即调用report.
+// off为该字符在本次调用输入中的偏移(跨调用拼接的字符可能为负), code为Unicode码点
+// (组合字符序列为 首码点<<32|次码点). 转换出错时不报告; 未调用report即为无损转换.
+func WithLossReport(report func(off int, code uint64)) Option {
+        return func(o *options) {
+                o.lossReport = report
+        }
+}
+
+// 加载反向检查所需的源字符集编码表及目标字符集解码表, 引用的映射表记入st.tables
+func loadRoundTrip(src, dst *charset, st *codeState) error {
+        var err error
+        if st.srcEncTbl, err = src.loadEnc(); err != nil {
+                return err
+        }
+        st.tables = append(st.tables, tableFiles(src.encFile)...)
+        if st.dstDecTbl, err = dst.loadDec(); err != nil {
+                return err
+        }
+        st.tables = append(st.tables, tableFiles(dst.decFile)...)
+        return nil
+}
+
+// 检查一个字符能否无损往返: b为源字节, e为输出字节, decMode及encMode为转换该字符前的移位状态
+func roundTrip(src, dst *charset, st *codeState, code uint64, b, e []byte, decMode, encMode int) bool {
+        if src.encode == nil {
+                return false
+        }
+        var buf [16]byte
+        es := codeState{encTbl: st.srcEncTbl, encMode: decMode}
+        m, err := encodeSeq(src, &es, code, buf[:])
+        // 编码结果可能带有切换移位状态的转义序列, 只比较末尾的字符部分
+        if err != nil || m < len(b) || string(buf[m-len(b):m]) != string(b) {
+                return false
+        }
+        ds := codeState{decTbl: st.dstDecTbl, decMode: encMode}
+        back, ok := decodeAll(dst, &ds, e)
+        return ok && back == code
+}
+
+// 将e解码为一个码点或两个码点打包的组合字符序列, 跳过转义序列
+func decodeAll(cs *charset, st *codeState, e []byte) (uint64, bool) {
+        var codes []uint64
+        for i := 0; i < len(e); {
+                code, n, err := cs.decode(st, e[i:])
+                if err != nil {
+                        return 0, false
+                }
+                if code != noCode {
+                        codes = append(codes, code)
+                }
+                i += n
+        }
+        switch len(codes) {
+        case 1:
+                return codes[0], true
+        case 2:
+                return codes[0]<<32 | codes[1], true
+        }
+        return 0, false
+}
+
+// 检查字符集映射表的一致性, 返回解码后不能编码回原值的字符集编码,
+// 以及编码后不能解码回原值的Unicode码点, 均按升序排列.
+// 不使用映射表的字符集(如UTF-8)返回nil, nil, nil.
+func CheckTable(name string) (codes []uint64, unicodes []uint64, err error) {
+        cs, ok := g_Charsets[charsetName(name)]
+        if !ok {
+                return nil, nil, fmt.Errorf("Error: 未知编码格式[%s]\n", name)
+        }
+        if cs.decFile == "nil" || cs.encFile == "nil" {
+                return nil, nil, nil
+        }
+        dec, err := cs.loadDec()
+        if err != nil {
+                return nil, nil, err
+        }
+        defer releaseTables([]string{cs.decFile})
+        enc, err := cs.loadEnc()
+        if err != nil {
+                return nil, nil, err
+        }
+        defer releaseTables([]string{cs.encFile})
+        for k, v := range dec {
+                if back, ok := enc[v]; !ok || back != k {
+                        codes = append(codes, k)
+                }
+        }
+        for k, v := range enc {
+                if back, ok := dec[v]; !ok || back != k {
+                        unicodes = append(unicodes, k)
+                }
+        }
+        sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+        sort.Slice(unicodes, func(i, j int) bool { return unicodes[i] < unicodes[j] })
+        return codes, unicodes, nil
+}
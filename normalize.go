@@ -0,0 +1,208 @@
+package better
+
+import (
+        "fmt"
+        "sort"
+)
+
+// 编码前的Unicode正规化. macOS文件名及部分网页表单中的文本为NFD(分解形式),
+// 如"é"为"e"加U+0301, GBK等字符集只有合成字符, 逐码点编码会失败;
+// 正规化为NFC后即可编码. NFKC还将兼容字符(全角字母, 带圈数字, 合字等)替换为对应的标准字符.
+// 正规化数据由cmd/gennorm根据Unicode字符数据库生成, 见normdata.go.
+
+type NormForm int
+
+const (
+        NormNone NormForm = iota // 不做正规化(默认)
+        NFC                      // 规范分解后规范合成
+        NFKC                     // 兼容分解后规范合成
+)
+
+func (f NormForm) String() string {
+        switch f {
+        case NormNone:
+                return "无"
+        case NFC:
+                return "NFC"
+        case NFKC:
+                return "NFKC"
+        }
+        return fmt.Sprintf("未知正规化形式(%d)", int(f))
+}
+
+// 编码前将解码结果正规化为form. 正规化以每次调用的输入为单位, 组合字符序列
+// 跨两次调用时(如分块转换的块边界落在"e"与U+0301之间)不会合成.
+// 与WithLossReport同时使用时, 被正规化改变的字符均报告为有损.
+func WithNormalization(form NormForm) Option {
+        return func(o *options) {
+                o.norm = form
+        }
+}
+
+// 韩文音节的算法分解及合成
+const (
+        hangulSBase  = 0xac00
+        hangulLBase  = 0x1100
+        hangulVBase  = 0x1161
+        hangulTBase  = 0x11a7
+        hangulLCount = 19
+        hangulVCount = 21
+        hangulTCount = 28
+        hangulNCount = hangulVCount * hangulTCount
+        hangulSCount = hangulLCount * hangulNCount
+)
+
+// 正规化使一个字符最多变为的字符数, 即完全分解的最大长度(Unicode 14.0.0中NFD为4, NFKD为18)
+func normExpand(form NormForm) int {
+        if form == NFKC {
+                return 18
+        }
+        return 4
+}
+
+func normStage(form NormForm) stage {
+        return func(chars []pivotChar) []pivotChar {
+                return normalize(chars, form == NFKC)
+        }
+}
+
+// 完全分解一个码点, 没有分解时返回nil
+func decompose(code uint64, compat bool) []uint64 {
+        if code >= hangulSBase && code < hangulSBase+hangulSCount {
+                s := code - hangulSBase
+                out := []uint64{hangulLBase + s/hangulNCount, hangulVBase + s%hangulNCount/hangulTCount}
+                if t := s % hangulTCount; t != 0 {
+                        out = append(out, hangulTBase+t)
+                }
+                return out
+        }
+        d, ok := "", false
+        if compat {
+                d, ok = g_CompatDecomposition[code]
+        }
+        if !ok {
+                d, ok = g_Decomposition[code]
+        }
+        if !ok {
+                return nil
+        }
+        var out []uint64
+        for _, r := range d {
+                out = append(out, uint64(r))
+        }
+        return out
+}
+
+// 两个码点的规范合成, 不能合成时ok为false
+func composePair(a, b uint64) (uint64, bool) {
+        switch {
+        case a >= hangulLBase && a < hangulLBase+hangulLCount && b >= hangulVBase && b < hangulVBase+hangulVCount:
+                return hangulSBase + ((a-hangulLBase)*hangulVCount+(b-hangulVBase))*hangulTCount, true
+        case a >= hangulSBase && a < hangulSBase+hangulSCount && (a-hangulSBase)%hangulTCount == 0 &&
+                b > hangulTBase && b < hangulTBase+hangulTCount:
+                return a + (b - hangulTBase), true
+        case a > 0xffffffff || b > 0xffffffff:
+                return 0, false
+        }
+        c, ok := g_Composition[a<<32|b]
+        return c, ok
+}
+
+// 对一次调用解码出的字符做分解, 规范排序及规范合成.
+// 结果中的字符取其第一个来源字符的偏移及移位状态; 与原字符相同的字符changed不变, 否则为true.
+// 打包的组合字符序列(大于32位)视为不可分解的起始字符.
+func normalize(chars []pivotChar, compat bool) []pivotChar {
+        type piece struct {
+                code uint64
+                src  int
+                ccc  uint8
+        }
+        pieces := make([]piece, 0, len(chars))
+        total := make([]int, len(chars)) // 各来源字符分解出的码点数
+        for k, c := range chars {
+                var d []uint64
+                if c.code <= 0xffffffff {
+                        d = decompose(c.code, compat)
+                }
+                if d == nil {
+                        d = []uint64{c.code}
+                }
+                for _, code := range d {
+                        var ccc uint8
+                        if code <= 0xffffffff {
+                                ccc = g_CombiningClass[code]
+                        }
+                        pieces = append(pieces, piece{code, k, ccc})
+                }
+                total[k] = len(d)
+        }
+
+        // 规范排序: 连续的非起始字符按组合类稳定排序
+        for a := 0; a < len(pieces); {
+                if pieces[a].ccc == 0 {
+                        a++
+                        continue
+                }
+                b := a + 1
+                for b < len(pieces) && pieces[b].ccc != 0 {
+                        b++
+                }
+                run := pieces[a:b]
+                sort.SliceStable(run, func(x, y int) bool { return run[x].ccc < run[y].ccc })
+                a = b
+        }
+
+        // 规范合成: 非起始字符与前面最近的起始字符之间没有组合类为0或不小于它的字符时可以合成
+        type group struct {
+                code  uint64
+                src   int  // 第一个来源字符
+                count int  // 码点数
+                mixed bool // 含多个来源字符
+                first int  // 来源字符在输入中的起止范围
+                last  int
+        }
+        groups := make([]group, 0, len(pieces))
+        starter := -1
+        lastCC := -1 // 起始字符之后最后一个未合成字符的组合类, -1表示紧接起始字符
+        for _, p := range pieces {
+                cc := int(p.ccc)
+                if starter >= 0 && (lastCC == -1 || (lastCC != 0 && lastCC < cc)) {
+                        if c, ok := composePair(groups[starter].code, p.code); ok {
+                                g := &groups[starter]
+                                g.code = c
+                                g.count++
+                                g.mixed = g.mixed || p.src != g.src
+                                if p.src < g.first {
+                                        g.first = p.src
+                                }
+                                if p.src > g.last {
+                                        g.last = p.src
+                                }
+                                continue
+                        }
+                }
+                groups = append(groups, group{p.code, p.src, 1, false, p.src, p.src})
+                if cc == 0 {
+                        starter, lastCC = len(groups)-1, -1
+                } else {
+                        lastCC = cc
+                }
+        }
+
+        out := make([]pivotChar, len(groups))
+        for k, g := range groups {
+                c := chars[g.src]
+                first, last := chars[g.first], chars[g.last]
+                out[k] = pivotChar{
+                        code:    g.code,
+                        off:     c.off,
+                        n:       c.n,
+                        decMode: c.decMode,
+                        changed: c.changed || g.mixed || g.count != total[g.src] || g.code != c.code,
+                }
+                if g.mixed {
+                        out[k].off, out[k].n = first.off, last.off+last.n-first.off
+                }
+        }
+        return out
+}
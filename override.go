@@ -0,0 +1,183 @@
+package better
+
+import (
+        "bufio"
+        "fmt"
+        "io"
+        "sort"
+        "strconv"
+        "strings"
+)
+
+// 用户自定义映射: 在内置映射表之上覆盖部分编码, 如GBK用户自定义区(0xAAA1~0xAFFE,
+// 0xF8A1~0xFEFE, 0xA140~0xA7A0)中厂商自定的字符. 覆盖只作用于设置它的转换器,
+// 内置映射表不变.
+
+type override struct {
+        name string
+        m    map[uint64]uint64
+}
+
+// 以m(字符集编码到Unicode码点)覆盖字符集name的内置映射, 解码和编码两个方向均优先使用m.
+// name所在的映射表(如GBK与GB2312, GB18030共用)为转换器的源编码时覆盖解码, 为目标编码时覆盖编码.
+// 创建转换器时检查冲突, 以下情况返回错误:
+//   - m中两个编码对应同一码点
+//   - m中的码点在内置映射表中已对应其它编码, 且该编码不在m中(编码方向将无法还原)
+//
+// m中的编码原来对应的码点若由该编码编码, 覆盖后该码点不再能编码.
+func WithOverrides(name string, m map[uint64]uint64) Option {
+        return func(o *options) {
+                o.overrides = append(o.overrides, override{name, m})
+        }
+}
+
+// 读取自定义映射文件, 格式与Unicode联盟的映射文件相同: 每行为"0x编码 0x码点", #后为注释
+func ReadOverrides(r io.Reader) (map[uint64]uint64, error) {
+        m := make(map[uint64]uint64)
+        sc := bufio.NewScanner(r)
+        for n := 1; sc.Scan(); n++ {
+                line := sc.Text()
+                if i := strings.IndexByte(line, '#'); i >= 0 {
+                        line = line[:i]
+                }
+                fields := strings.Fields(line)
+                if len(fields) == 0 {
+                        continue
+                }
+                if len(fields) != 2 {
+                        return nil, fmt.Errorf("第%d行格式错误", n)
+                }
+                code, err1 := strconv.ParseUint(strings.TrimPrefix(fields[0], "0x"), 16, 64)
+                unicode, err2 := strconv.ParseUint(strings.TrimPrefix(fields[1], "0x"), 16, 64)
+                if err1 != nil || err2 != nil {
+                        return nil, fmt.Errorf("第%d行格式错误", n)
+                }
+                if _, ok := m[code]; ok {
+                        return nil, fmt.Errorf("第%d行: 编码[0x%x]重复", n, code)
+                }
+                m[code] = unicode
+        }
+        return m, sc.Err()
+}
+
+// 将自定义映射及私用区策略应用到源编码及目标编码的映射表
+func customizeTables(s, d *charset, st *codeState, o *options) error {
+        done := make(map[string]bool)
+        for _, ov := range o.overrides {
+                cs, ok := g_Charsets[charsetName(ov.name)]
+                if !ok {
+                        return fmt.Errorf("Error: 未知编码格式[%s]\n", ov.name)
+                }
+                if cs.table != s.table && cs.table != d.table {
+                        return fmt.Errorf("自定义映射的编码[%s]不是转换器的源编码或目标编码", ov.name)
+                }
+                if cs.decFile == "nil" || cs.encFile == "nil" {
+                        return fmt.Errorf("编码[%s]不使用映射表, 不能自定义映射", ov.name)
+                }
+                if done[cs.table] {
+                        return fmt.Errorf("重复设置编码[%s]的自定义映射", ov.name)
+                }
+                done[cs.table] = true
+        }
+        if (o.pua == PUACP936 || o.pua == PUAGB18030_2022) && s.table != "GBK" && d.table != "GBK" {
+                return fmt.Errorf("私用区策略[%v]只适用于GBK系列编码", o.pua)
+        }
+        dec, enc, err := customTables(s, o)
+        if err != nil {
+                return err
+        }
+        if dec != nil {
+                st.decTbl = dec
+                if st.srcEncTbl != nil {
+                        st.srcEncTbl = enc
+                }
+        }
+        if dec, enc, err = customTables(d, o); err != nil {
+                return err
+        }
+        if dec != nil {
+                st.encTbl = enc
+                if st.dstDecTbl != nil {
+                        st.dstDecTbl = dec
+                }
+        }
+        return nil
+}
+
+// 返回cs应用私用区策略及自定义映射后的解码表和编码表, 均不需要时返回nil
+func customTables(cs *charset, o *options) (map[uint64]uint64, map[uint64]uint64, error) {
+        var m map[uint64]uint64
+        for _, ov := range o.overrides {
+                if g_Charsets[charsetName(ov.name)].table == cs.table {
+                        m = ov.m
+                }
+        }
+        policy := cs.table == "GBK" && (o.pua == PUACP936 || o.pua == PUAGB18030_2022)
+        if m == nil && !policy {
+                return nil, nil, nil
+        }
+        // 返回的是修改后的副本, 原映射表只在此期间引用
+        dec, err := cs.loadDec()
+        if err != nil {
+                return nil, nil, err
+        }
+        defer releaseTables(tableFiles(cs.decFile))
+        enc, err := cs.loadEnc()
+        if err != nil {
+                return nil, nil, err
+        }
+        defer releaseTables(tableFiles(cs.encFile))
+        if policy {
+                if dec, enc, err = puaTables(dec, enc, o.pua); err != nil {
+                        return nil, nil, err
+                }
+        }
+        if m != nil {
+                if dec, enc, err = overrideTables(dec, enc, m); err != nil {
+                        return nil, nil, err
+                }
+        }
+        return dec, enc, nil
+}
+
+// 复制映射表并应用自定义映射, 返回新的解码表和编码表
+func overrideTables(baseDec, baseEnc map[uint64]uint64, m map[uint64]uint64) (map[uint64]uint64, map[uint64]uint64, error) {
+        codes := make([]uint64, 0, len(m))
+        for code := range m {
+                codes = append(codes, code)
+        }
+        sort.Slice(codes, func(i, j int) bool { return codes[i] < codes[j] })
+        targets := make(map[uint64]uint64, len(m))
+        for _, code := range codes {
+                unicode := m[code]
+                if other, ok := targets[unicode]; ok {
+                        return nil, nil, fmt.Errorf("自定义映射冲突: 编码[0x%x]与[0x%x]均对应码点[0x%x]", other, code, unicode)
+                }
+                targets[unicode] = code
+                if other, ok := baseEnc[unicode]; ok && other != code {
+                        if _, ok := m[other]; !ok {
+                                return nil, nil, fmt.Errorf("自定义映射冲突: 码点[0x%x]已对应编码[0x%x]", unicode, other)
+                        }
+                }
+        }
+        dec := make(map[uint64]uint64, len(baseDec)+len(m))
+        for k, v := range baseDec {
+                dec[k] = v
+        }
+        enc := make(map[uint64]uint64, len(baseEnc)+len(m))
+        for k, v := range baseEnc {
+                enc[k] = v
+        }
+        for _, code := range codes {
+                if old, ok := baseDec[code]; ok && enc[old] == code {
+                        if _, ok := targets[old]; !ok {
+                                delete(enc, old)
+                        }
+                }
+        }
+        for code, unicode := range m {
+                dec[code] = unicode
+                enc[unicode] = code
+        }
+        return dec, enc, nil
+}
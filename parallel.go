@@ -0,0 +1,261 @@
+package better
+
+import (
+        "fmt"
+        "runtime"
+        "strings"
+        "sync"
+)
+
+// 大数据的并行转换: 在字符边界处将输入分为若干段, 各段在不同的goroutine中转换后按顺序拼接,
+// 结果与整体顺序转换相同.
+
+const (
+        parallelMinChunk = 1 << 20   // 每段至少1MB, 输入较小时减少段数
+        parallelBlock    = 256 << 10 // 段内每次转换的输入长度(近似值, 在字符边界处截断)
+)
+
+// 一段的转换结果, 报告的偏移均为在整个输入中的偏移
+type parallelResult struct {
+        out     []byte
+        err     error
+        lost    []lossEntry
+        omitted []omitEntry
+}
+
+// 将src由from编码并行转换为to编码, workers为使用的goroutine数, 不大于0时为GOMAXPROCS.
+// 输入在不会落在字符中间的位置分段: UTF-8, GBK, Big5等兼容ASCII的编码在小于0x30的
+// 字节(控制字符, 空格及部分标点)之后分段, 这些字节不会是多字节字符的首字节或尾字节;
+// UTF-16/UTF-32按码元对齐且不拆开代理对; 单字节编码可在任意位置分段. ISO-2022-JP, HZ
+// 等有移位状态的编码不能分段, 按一段转换. opts与NewCoderByName相同, 按WithStream处理:
+// 只跳过输入开头的BOM, 只在输出开头写BOM.
+// 转换失败时返回出错位置之前的转换结果及*ConvertError, 其Offset为在src中的偏移,
+// Written为返回的结果长度; 有多处错误时报告最前面的一处. WithLossReport及WithOmitInvalid
+// 的回调在全部转换完成后按偏移顺序调用.
+func ConvertParallel(from, to string, src []byte, workers int, opts ...Option) ([]byte, error) {
+        from, to = charsetName(from), charsetName(to)
+        cs, ok := g_Charsets[from]
+        if !ok {
+                return nil, fmt.Errorf("Error: 未知编码格式[%s]\n", from)
+        }
+        if cs.detect != nil && len(src) > 0 {
+                from = cs.detect(src)
+                cs = g_Charsets[from]
+        }
+
+        // 有专门的转换函数且两端都没有BOM时, 各段直接使用该函数
+        var fast func([]byte, []byte) (int, error)
+        var bound func(n int) int // 转换n字节所需的输出空间
+        if len(opts) == 0 && len(cs.bom) == 0 {
+                if d, ok := g_Charsets[to]; ok && len(d.bom) == 0 {
+                        for idx, ele := range g_CodeMap {
+                                if ele.fn != nil && ele.src == from && ele.dst == to {
+                                        c, err := NewCoder(idx)
+                                        if err != nil {
+                                                return nil, err
+                                        }
+                                        defer c.Close()
+                                        fast = c.Convert
+                                        // 在此计算并缓存比值, 各goroutine只读
+                                        c.MaxEncodedLen(0)
+                                        bound = c.MaxEncodedLen
+                                        break
+                                }
+                        }
+                }
+        }
+        var s, d *charset
+        var st *codeState
+        if fast == nil {
+                var err error
+                if s, d, st, err = newPivotState(from, to, opts...); err != nil {
+                        return nil, err
+                }
+                defer releaseTables(st.tables)
+                num, den := st.maxRatio(s, d, from, to)
+                fixed := fixedCost(d, to)
+                bound = func(n int) int {
+                        return maxEncodedLen(num, den, maxPending, fixed, n)
+                }
+        }
+
+        if workers <= 0 {
+                workers = runtime.GOMAXPROCS(0)
+        }
+        if n := len(src) / parallelMinChunk; n < workers {
+                workers = n
+        }
+        bounds := []int{0}
+        for k := 1; k < workers; k++ {
+                at, ok := splitPoint(cs, from, src, len(src)*k/workers)
+                if !ok {
+                        break
+                }
+                if at > bounds[len(bounds)-1] && at < len(src) {
+                        bounds = append(bounds, at)
+                }
+        }
+        bounds = append(bounds, len(src))
+
+        results := make([]parallelResult, len(bounds)-1)
+        var wg sync.WaitGroup
+        for k := range results {
+                wg.Add(1)
+                go func(k int) {
+                        defer wg.Done()
+                        chunk, base := src[bounds[k]:bounds[k+1]], bounds[k]
+                        if fast != nil {
+                                convertChunkFast(fast, bound, cs, from, chunk, base, &results[k])
+                        } else {
+                                convertChunkPivot(s, d, *st, bound, k == 0, k == len(results)-1, from, chunk, base, &results[k])
+                        }
+                }(k)
+        }
+        wg.Wait()
+
+        size := 0
+        for _, r := range results {
+                size += len(r.out)
+        }
+        out := make([]byte, 0, size)
+        var err error
+        var lost []lossEntry
+        var omitted []omitEntry
+        for _, r := range results {
+                out = append(out, r.out...)
+                lost = append(lost, r.lost...)
+                omitted = append(omitted, r.omitted...)
+                if r.err != nil {
+                        err = r.err
+                        if ce, ok := err.(*ConvertError); ok {
+                                ce.Written = len(out)
+                        }
+                        break
+                }
+        }
+        if st != nil {
+                for _, l := range lost {
+                        st.lossReport(l.off, l.code)
+                }
+                for _, o := range omitted {
+                        st.omitReport(o.off, o.err)
+                }
+        }
+        return out, err
+}
+
+// 返回不小于at的第一个可分段位置, 没有时返回len(src); 编码不能分段时ok为false
+func splitPoint(cs *charset, name string, src []byte, at int) (int, bool) {
+        if at >= len(src) {
+                return len(src), true
+        }
+        switch {
+        case strings.HasPrefix(name, "UTF-16") || strings.HasPrefix(name, "UCS-2"):
+                at += at & 1
+                // 不在高代理与低代理之间分段
+                if at+1 < len(src) {
+                        hi := src[at+1]
+                        if strings.HasSuffix(name, "BE") {
+                                hi = src[at]
+                        }
+                        if hi&0xfc == 0xdc {
+                                at += 2
+                        }
+                }
+        case strings.HasPrefix(name, "UTF-32"):
+                at = (at + 3) &^ 3
+        case strings.HasPrefix(cs.decFile, "sbcs/"):
+        case cs.ascii:
+                for k := at; k < len(src); k++ {
+                        if src[k] < 0x30 {
+                                return k + 1, true
+                        }
+                }
+                if name != "UTF-8" {
+                        return len(src), true
+                }
+                // UTF-8没有这样的字节时在字符开头分段
+                for at < len(src) && src[at]&0xc0 == 0x80 {
+                        at++
+                }
+        default:
+                return 0, false
+        }
+        if at > len(src) {
+                at = len(src)
+        }
+        return at, true
+}
+
+// 出错时保留出错位置之前的结果, 并将错误的偏移换算为在整个输入中的偏移
+func (r *parallelResult) fail(err error, buf []byte, base int) {
+        ce, ok := err.(*ConvertError)
+        if !ok {
+                ce = &ConvertError{0, 0, err}
+        }
+        r.out = append(r.out, buf[:ce.Written]...)
+        r.err = &ConvertError{base + ce.Offset, len(r.out), ce.Err}
+}
+
+// 用专门的转换函数转换一段: 函数没有跨调用的状态, 段内也在字符边界处分块
+func convertChunkFast(fn func([]byte, []byte) (int, error), bound func(int) int, cs *charset, name string, src []byte, base int, r *parallelResult) {
+        var buf []byte
+        for pos := 0; pos < len(src); {
+                end, _ := splitPoint(cs, name, src, pos+parallelBlock)
+                if need := bound(end - pos); len(buf) < need {
+                        buf = make([]byte, need)
+                }
+                n, err := fn(src[pos:end], buf)
+                if err != nil {
+                        r.fail(err, buf, base+pos)
+                        return
+                }
+                r.out = append(r.out, buf[:n]...)
+                pos = end
+        }
+}
+
+// 经Unicode中转转换一段, st为该段独立的状态; 后面的段不处理BOM
+func convertChunkPivot(s, d *charset, st codeState, bound func(int) int, first, last bool, name string, src []byte, base int, r *parallelResult) {
+        st.stream = true
+        st.inStarted, st.outStarted = !first, !first
+        blockBase := base
+        if st.lossReport != nil {
+                st.lossReport = func(off int, code uint64) {
+                        r.lost = append(r.lost, lossEntry{blockBase + off, code})
+                }
+        }
+        if st.omitReport != nil {
+                st.omitReport = func(off int, err error) {
+                        r.omitted = append(r.omitted, omitEntry{blockBase + off, err})
+                }
+        }
+        var buf []byte
+        for pos := 0; pos < len(src); {
+                end, ok := splitPoint(s, name, src, pos+parallelBlock)
+                if !ok {
+                        end = pos + parallelBlock
+                        if end > len(src) {
+                                end = len(src)
+                        }
+                }
+                if need := bound(end - pos); len(buf) < need {
+                        buf = make([]byte, need)
+                }
+                blockBase = base + pos
+                n, err := pivotConvert(s, d, &st, src[pos:end], buf)
+                if err != nil {
+                        r.fail(err, buf, blockBase)
+                        return
+                }
+                r.out = append(r.out, buf[:n]...)
+                pos = end
+        }
+        if n := len(st.pending); n > 0 {
+                err := fmt.Errorf("%d字节不完整的字符", n)
+                if last {
+                        err = fmt.Errorf("输入末尾有%d字节不完整的字符", n)
+                }
+                r.err = &ConvertError{base + len(src) - n, len(r.out), err}
+        }
+}
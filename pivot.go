@@ -0,0 +1,680 @@
+package better
+
+import (
+        "bytes"
+        "errors"
+        "fmt"
+        "sort"
+)
+
+// 经Unicode中转的转换: 源字符集逐字符解码为Unicode码点, 再由目标字符集编码.
+// 新增的字符集只需实现单个字符的编解码, 即可与其它字符集互相转换.
+
+var errShortBuf = errors.New("输出缓冲区空间不足")
+
+// 输入在一个字符中间结束: 流式转换时剩余字节留待下次调用时继续转换, 否则为错误
+var errShortSrc = errors.New("输入不完整")
+
+// 解码结果不是字符(如转义序列), 无需编码
+const noCode = ^uint64(0)
+
+// 转换状态, 在同一Converter的多次调用之间保持, 以支持分块的流式转换
+type codeState struct {
+        decTbl   map[uint64]uint64
+        encTbl   map[uint64]uint64
+        decMode  int    // 解码移位状态
+        encMode  int    // 编码移位状态
+        pending  []byte // 上次调用末尾不完整的字符
+        detected string // 自动识别出的源编码
+
+        // 以下仅在设置WithLossReport时使用
+        srcEncTbl  map[uint64]uint64 // 源字符集的编码表, 用于检查解码结果能否还原
+        dstDecTbl  map[uint64]uint64 // 目标字符集的解码表, 用于检查编码结果能否还原
+        lossReport func(off int, code uint64)
+
+        stream     bool // 多次调用视为同一数据流, BOM只在流的开头处理
+        inStarted  bool // 已处理过输入开头的BOM
+        outStarted bool // 已输出BOM
+        omit       bool
+        omitReport func(off int, err error)
+        pua        PUAPolicy
+        puaSubst   uint64   // PUASubstitute的替换字符
+        stages     []stage  // 解码后, 编码前对字符序列的处理(如正规化), 依次执行
+        ascii      bool     // 连续的ASCII字节可直接复制
+        expand     int      // 处理阶段使一个字符最多变为几个字符
+        tables     []string // 引用的映射表文件, 转换器关闭时释放
+}
+
+// 解码出的一个字符. off及n为其在本次调用输入中的位置, decMode为解码前的移位状态,
+// changed表示已被处理阶段改变, 不能由源字节无损往返
+type pivotChar struct {
+        code    uint64
+        off     int
+        n       int
+        decMode int
+        changed bool
+}
+
+// 以一次调用解码出的全部字符为单位的处理阶段
+type stage func(chars []pivotChar) []pivotChar
+
+// 创建转换器时的可选设置
+type Option func(*options)
+
+type options struct {
+        lossReport func(off int, code uint64)
+        stream     bool
+        omit       bool
+        omitReport func(off int, err error)
+        overrides  []override
+        pua        PUAPolicy
+        norm       NormForm
+        width      WidthForm
+        widthAlnum bool
+        chinese    *ChineseConverter
+}
+
+// 分块流式转换: 多次调用的输入视为同一数据流, 源编码的BOM只在流的开头跳过,
+// 目标编码的BOM只在流的开头输出, 输入末尾不完整的字符及解码的移位状态保留到下次调用,
+// 调用Flush后开始新的数据流.
+// 不使用要求输入为完整字符的专门转换函数, 一律经Unicode中转.
+func WithStream() Option {
+        return func(o *options) {
+                o.stream = true
+        }
+}
+
+// 跳过无法解码或目标编码中没有对应字符的输入, 而不是返回错误(同iconv -c).
+// 每跳过一处调用report(可为nil), off为其在本次调用输入中的偏移; 无法解码时跳过一个字节.
+func WithOmitInvalid(report func(off int, err error)) Option {
+        return func(o *options) {
+                o.omit = true
+                o.omitReport = report
+        }
+}
+
+// 返回所有支持的编码名称, 按字母顺序排列
+func Charsets() []string {
+        names := make([]string, 0, len(g_Charsets))
+        for name := range g_Charsets {
+                names = append(names, name)
+        }
+        sort.Strings(names)
+        return names
+}
+
+type charset struct {
+        decFile string // 字符集到Unicode的映射表, "nil"表示不需要映射表
+        encFile string // Unicode到字符集的映射表, "nil"表示不需要映射表
+        table   string // 映射表文件中记录的字符集名称, 为空时与字符集名称相同
+        bom     []byte // 编码时写在输出开头, 解码时跳过
+        // 解码from开头的一个字符, 返回Unicode码点及消耗的字节数
+        decode func(st *codeState, from []byte) (uint64, int, error)
+        // 将一个Unicode码点编码到to中, 返回写入的字节数
+        encode func(st *codeState, code uint64, to []byte) (int, error)
+        // 编码结束时将移位状态复位, 返回写入的字节数
+        reset func(st *codeState, to []byte) (int, error)
+        // 字节序自动识别, 返回实际使用的字符集名; 作为目标编码时使用bom及encode(小端)
+        detect func(from []byte) string
+        // 映射表中含组合字符序列(两个码点打包为 首码点<<32|次码点)
+        combine bool
+        // encFile是字符集到Unicode的映射表, 加载后反转使用
+        reverse bool
+        // ASCII字节解码为相同的码点, ASCII码点编码为相同的字节, 且不改变移位状态
+        ascii bool
+}
+
+var g_Charsets = map[string]*charset{
+        "UTF-8":        &charset{decFile: "nil", encFile: "nil", decode: decodeUTF8, encode: encodeUTF8, ascii: true},
+        "GBK":          &charset{decFile: "Gbk2Unicode.db", encFile: "Unicode2Gbk.db", decode: decodeGBK, encode: encodeGBK, ascii: true},
+        "GB2312":       &charset{decFile: "Gbk2Unicode.db", encFile: "Unicode2Gbk.db", table: "GBK", decode: decodeGBK, encode: encodeGBK, ascii: true},
+        "GB18030":      &charset{decFile: "Gbk2Unicode.db", encFile: "Unicode2Gbk.db", table: "GBK", decode: decodeGBK, encode: encodeGBK, ascii: true},
+        "UTF-16LE":     &charset{decFile: "nil", encFile: "nil", bom: []byte{0xff, 0xfe}, decode: decodeUTF16LE, encode: encodeUTF16LE},
+        "UTF-16BE":     &charset{decFile: "nil", encFile: "nil", bom: []byte{0xfe, 0xff}, decode: decodeUTF16BE, encode: encodeUTF16BE},
+        "UTF-16":       &charset{decFile: "nil", encFile: "nil", bom: []byte{0xff, 0xfe}, encode: encodeUTF16LE, detect: detectUTF16},
+        "UTF-32LE":     &charset{decFile: "nil", encFile: "nil", bom: []byte{0xff, 0xfe, 0x00, 0x00}, decode: decodeUTF32LE, encode: encodeUTF32LE},
+        "UTF-32BE":     &charset{decFile: "nil", encFile: "nil", bom: []byte{0x00, 0x00, 0xfe, 0xff}, decode: decodeUTF32BE, encode: encodeUTF32BE},
+        "UTF-32":       &charset{decFile: "nil", encFile: "nil", bom: []byte{0xff, 0xfe, 0x00, 0x00}, encode: encodeUTF32LE, detect: detectUTF32},
+        "UCS-2LE":      &charset{decFile: "nil", encFile: "nil", bom: []byte{0xff, 0xfe}, decode: decodeUCS2LE, encode: encodeUCS2LE},
+        "UCS-2BE":      &charset{decFile: "nil", encFile: "nil", bom: []byte{0xfe, 0xff}, decode: decodeUCS2BE, encode: encodeUCS2BE},
+        "BIG5":         &charset{decFile: "Big52Unicode.db", encFile: "Unicode2Big5.db", decode: decodeBig5, encode: encodeBig5, ascii: true},
+        "BIG5-HKSCS":   &charset{decFile: "Big5hkscs2Unicode.db", encFile: "Unicode2Big5hkscs.db", decode: decodeBig5, encode: encodeBig5, combine: true, ascii: true},
+        "SHIFT_JIS":    &charset{decFile: "Sjis2Unicode.db", encFile: "Unicode2Sjis.db", decode: decodeSJIS, encode: encodeMBCS, ascii: true},
+        "CP932":        &charset{decFile: "Cp9322Unicode.db", encFile: "Unicode2Cp932.db", decode: decodeSJIS, encode: encodeMBCS, ascii: true},
+        "EUC-JP":       &charset{decFile: "Eucjp2Unicode.db", encFile: "Unicode2Eucjp.db", decode: decodeEUCJP, encode: encodeMBCS, ascii: true},
+        "ISO-2022-JP":  &charset{decFile: "Eucjp2Unicode.db", encFile: "Unicode2Eucjp.db", table: "EUC-JP", decode: decodeISO2022JP, encode: encodeISO2022JP, reset: resetISO2022JP},
+        "EUC-KR":       &charset{decFile: "Cp9492Unicode.db", encFile: "Unicode2Cp949.db", table: "CP949", decode: decodeEUCKR, encode: encodeEUCKR, ascii: true},
+        "CP949":        &charset{decFile: "Cp9492Unicode.db", encFile: "Unicode2Cp949.db", decode: decodeCP949, encode: encodeMBCS, ascii: true},
+        "ISO-2022-KR":  &charset{decFile: "Cp9492Unicode.db", encFile: "Unicode2Cp949.db", table: "CP949", decode: decodeISO2022KR, encode: encodeISO2022KR, reset: resetISO2022KR},
+        "ISO-8859-1":   &charset{decFile: "sbcs/ISO-8859-1.db", encFile: "sbcs/ISO-8859-1.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-2":   &charset{decFile: "sbcs/ISO-8859-2.db", encFile: "sbcs/ISO-8859-2.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-3":   &charset{decFile: "sbcs/ISO-8859-3.db", encFile: "sbcs/ISO-8859-3.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-4":   &charset{decFile: "sbcs/ISO-8859-4.db", encFile: "sbcs/ISO-8859-4.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-5":   &charset{decFile: "sbcs/ISO-8859-5.db", encFile: "sbcs/ISO-8859-5.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-6":   &charset{decFile: "sbcs/ISO-8859-6.db", encFile: "sbcs/ISO-8859-6.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-7":   &charset{decFile: "sbcs/ISO-8859-7.db", encFile: "sbcs/ISO-8859-7.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-8":   &charset{decFile: "sbcs/ISO-8859-8.db", encFile: "sbcs/ISO-8859-8.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-9":   &charset{decFile: "sbcs/ISO-8859-9.db", encFile: "sbcs/ISO-8859-9.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-10":  &charset{decFile: "sbcs/ISO-8859-10.db", encFile: "sbcs/ISO-8859-10.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-11":  &charset{decFile: "sbcs/ISO-8859-11.db", encFile: "sbcs/ISO-8859-11.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-13":  &charset{decFile: "sbcs/ISO-8859-13.db", encFile: "sbcs/ISO-8859-13.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-14":  &charset{decFile: "sbcs/ISO-8859-14.db", encFile: "sbcs/ISO-8859-14.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-15":  &charset{decFile: "sbcs/ISO-8859-15.db", encFile: "sbcs/ISO-8859-15.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "ISO-8859-16":  &charset{decFile: "sbcs/ISO-8859-16.db", encFile: "sbcs/ISO-8859-16.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1250": &charset{decFile: "sbcs/WINDOWS-1250.db", encFile: "sbcs/WINDOWS-1250.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1251": &charset{decFile: "sbcs/WINDOWS-1251.db", encFile: "sbcs/WINDOWS-1251.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1252": &charset{decFile: "sbcs/WINDOWS-1252.db", encFile: "sbcs/WINDOWS-1252.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1253": &charset{decFile: "sbcs/WINDOWS-1253.db", encFile: "sbcs/WINDOWS-1253.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1254": &charset{decFile: "sbcs/WINDOWS-1254.db", encFile: "sbcs/WINDOWS-1254.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1255": &charset{decFile: "sbcs/WINDOWS-1255.db", encFile: "sbcs/WINDOWS-1255.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1256": &charset{decFile: "sbcs/WINDOWS-1256.db", encFile: "sbcs/WINDOWS-1256.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1257": &charset{decFile: "sbcs/WINDOWS-1257.db", encFile: "sbcs/WINDOWS-1257.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "WINDOWS-1258": &charset{decFile: "sbcs/WINDOWS-1258.db", encFile: "sbcs/WINDOWS-1258.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "KOI8-R":       &charset{decFile: "sbcs/KOI8-R.db", encFile: "sbcs/KOI8-R.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "KOI8-U":       &charset{decFile: "sbcs/KOI8-U.db", encFile: "sbcs/KOI8-U.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "CP437":        &charset{decFile: "sbcs/CP437.db", encFile: "sbcs/CP437.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "CP850":        &charset{decFile: "sbcs/CP850.db", encFile: "sbcs/CP850.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "CP866":        &charset{decFile: "sbcs/CP866.db", encFile: "sbcs/CP866.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true, ascii: true},
+        "CP037":        &charset{decFile: "sbcs/CP037.db", encFile: "sbcs/CP037.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true},
+        "CP500":        &charset{decFile: "sbcs/CP500.db", encFile: "sbcs/CP500.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true},
+        "CP1047":       &charset{decFile: "sbcs/CP1047.db", encFile: "sbcs/CP1047.db", decode: decodeSBCS, encode: encodeSBCS, reverse: true},
+        "IBM-935":      &charset{decFile: "Ibm9352Unicode.db", encFile: "Unicode2Ibm935.db", decode: decodeEBCDICDBCS, encode: encodeEBCDICDBCS, reset: resetEBCDICDBCS},
+        "IBM-1388":     &charset{decFile: "Ibm13882Unicode.db", encFile: "Unicode2Ibm1388.db", decode: decodeEBCDICDBCS, encode: encodeEBCDICDBCS, reset: resetEBCDICDBCS},
+        "HZ-GB-2312":   &charset{decFile: "Gbk2Unicode.db", encFile: "Unicode2Gbk.db", table: "GBK", decode: decodeHZ, encode: encodeHZ, reset: resetHZ},
+        "ISO-2022-CN":  &charset{decFile: "Gbk2Unicode.db", encFile: "Unicode2Gbk.db", table: "GBK", decode: decodeISO2022CN, encode: encodeISO2022CN, reset: resetISO2022CN},
+}
+
+func init() {
+        for name, cs := range g_Charsets {
+                if cs.table == "" {
+                        cs.table = name
+                }
+        }
+}
+
+// 按编码名称创建转换器, 名称不区分大小写, 也可使用常见别名(如LATIN1, CP1252, SJIS).
+// 两种编码之间有专门的转换函数时使用该函数, 否则经Unicode中转.
+func NewCoderByName(from, to string, opts ...Option) (Converter, error) {
+        from, to = charsetName(from), charsetName(to)
+        _, ok1 := g_Charsets[from]
+        _, ok2 := g_Charsets[to]
+        for idx, ele := range g_CodeMap {
+                if ok1 && ok2 && ele.fn != nil && ele.src == from && ele.dst == to {
+                        return NewCoder(idx, opts...)
+                }
+        }
+        return newPivotCoder(from, to, opts...)
+}
+
+// 经Unicode中转的转换器
+type pivotCoder struct {
+        s, d     *charset
+        src, dst string
+        st       *codeState
+        closed   bool
+        num, den int // 每字节最多输出num/den字节, den为0时尚未计算
+}
+
+func newPivotCoder(src, dst string, opts ...Option) (Converter, error) {
+        s, d, st, err := newPivotState(src, dst, opts...)
+        if err != nil {
+                return nil, err
+        }
+        return &pivotCoder{s: s, d: d, src: src, dst: dst, st: st}, nil
+}
+
+func (c *pivotCoder) Convert(in, out []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        return pivotConvert(c.s, c.d, c.st, in, out)
+}
+
+// 源编码需识别字节序(UTF-16, UTF-32)时, 返回最近一次转换识别出的编码(如UTF-16BE),
+// 识别之前返回创建时的名称
+func (c *pivotCoder) Source() string {
+        if c.st.detected != "" {
+                return c.st.detected
+        }
+        return c.src
+}
+
+func (c *pivotCoder) Target() string { return c.dst }
+
+func (c *pivotCoder) ratio() (int, int) {
+        if c.den == 0 {
+                c.num, c.den = c.st.maxRatio(c.s, c.d, c.src, c.dst)
+        }
+        return c.num, c.den
+}
+
+func (c *pivotCoder) MaxOutputPerInputByte() int {
+        return perByte(c.ratio())
+}
+
+func (c *pivotCoder) MaxEncodedLen(n int) int {
+        num, den := c.ratio()
+        return maxEncodedLen(num, den, maxPending, fixedCost(c.d, c.dst), n)
+}
+
+// 在状态的副本上转换, 不调用WithLossReport及WithOmitInvalid的回调
+func (c *pivotCoder) EncodedLen(src []byte) (int, error) {
+        if c.closed {
+                return 0, errClosed
+        }
+        st := *c.st
+        st.lossReport, st.omitReport = nil, nil
+        return pivotConvert(c.s, c.d, &st, src, nil)
+}
+
+func (c *pivotCoder) Flush() error {
+        pending := len(c.st.pending)
+        c.Reset()
+        if pending > 0 {
+                return fmt.Errorf("输入末尾有%d字节不完整的字符", pending)
+        }
+        return nil
+}
+
+func (c *pivotCoder) Reset() {
+        st := c.st
+        st.decMode, st.encMode, st.pending, st.detected = 0, 0, nil, ""
+        st.inStarted, st.outStarted = false, false
+}
+
+func (c *pivotCoder) Close() error {
+        if !c.closed {
+                releaseTables(c.st.tables)
+                c.closed = true
+        }
+        return nil
+}
+
+// 加载映射表并按opts建立转换状态
+func newPivotState(src, dst string, opts ...Option) (*charset, *charset, *codeState, error) {
+        s, ok := g_Charsets[src]
+        if !ok {
+                return nil, nil, nil, fmt.Errorf("Error: 未知编码格式[%s]\n", src)
+        }
+        d, ok := g_Charsets[dst]
+        if !ok || d.encode == nil {
+                return nil, nil, nil, fmt.Errorf("Error: 未知编码格式[%s]\n", dst)
+        }
+        st := new(codeState)
+        // 出错时释放已引用的映射表
+        fail := func(err error) (*charset, *charset, *codeState, error) {
+                releaseTables(st.tables)
+                return nil, nil, nil, err
+        }
+        var err error
+        if st.decTbl, err = s.loadDec(); err != nil {
+                return fail(err)
+        }
+        st.tables = tableFiles(s.decFile)
+        if st.encTbl, err = d.loadEnc(); err != nil {
+                return fail(err)
+        }
+        st.tables = append(st.tables, tableFiles(d.encFile)...)
+        var o options
+        for _, opt := range opts {
+                opt(&o)
+        }
+        if o.lossReport != nil {
+                if err = loadRoundTrip(s, d, st); err != nil {
+                        return fail(err)
+                }
+                st.lossReport = o.lossReport
+        }
+        if err = customizeTables(s, d, st, &o); err != nil {
+                return fail(err)
+        }
+        st.stream, st.omit, st.omitReport = o.stream, o.omit, o.omitReport
+        st.pua, st.puaSubst = o.pua, 0xfffd
+        if d.encFile != "nil" {
+                st.puaSubst = '?'
+        }
+        st.ascii = s.ascii && d.ascii && !d.combine && len(o.overrides) == 0
+        st.expand = 1
+        if o.norm != NormNone {
+                st.stages = append(st.stages, normStage(o.norm))
+                st.expand *= normExpand(o.norm)
+        }
+        if o.chinese != nil {
+                if err = o.chinese.check(); err != nil {
+                        return fail(err)
+                }
+                st.stages = append(st.stages, chineseStage(o.chinese))
+                st.expand *= o.chinese.expand()
+        }
+        if o.width != WidthNone {
+                st.stages = append(st.stages, widthStage(o.width, o.widthAlnum))
+        }
+        return s, d, st, nil
+}
+
+// 使用的映射表文件, 去掉表示不使用映射表的"nil"
+func tableFiles(files ...string) []string {
+        var ret []string
+        for _, f := range files {
+                if f != "nil" {
+                        ret = append(ret, f)
+                }
+        }
+        return ret
+}
+
+type omitEntry struct {
+        off int
+        err error
+}
+
+// 流式转换时输入末尾不完整的字符保存在状态中, 与下次调用的输入拼接后继续转换;
+// 非流式转换时视为错误. 出错时状态恢复为调用前的状态, 返回*ConvertError.
+// to为nil时只计算输出的字节数
+func pivotConvert(src, dst *charset, st *codeState, from []byte, to []byte) (int, error) {
+        saved := *st
+        count := to == nil
+        // 只计数时编码到这里. 编码函数经函数值调用, 数组会逃逸到堆上, 因此只在计数时分配
+        var scratch []byte
+        if count {
+                scratch = make([]byte, 32)
+        }
+        if len(st.pending) > 0 {
+                from = append(st.pending[:len(st.pending):len(st.pending)], from...)
+                st.pending = nil
+        }
+        if src.detect != nil {
+                // 非流式转换时每次调用的输入各自识别
+                if st.detected == "" || !st.stream {
+                        if len(from) == 0 {
+                                return 0, nil
+                        }
+                        st.detected = src.detect(from)
+                }
+                src = g_Charsets[st.detected]
+        }
+        i := 0
+        j := 0
+        // 出错时恢复状态, off为出错字符在拼接后输入中的偏移
+        fail := func(off int, err error) (int, error) {
+                *st = saved
+                return 0, &ConvertError{off - len(saved.pending), j, err}
+        }
+        if !st.stream || !st.inStarted {
+                if len(src.bom) > 0 && bytes.HasPrefix(from, src.bom) {
+                        i += len(src.bom)
+                }
+        }
+        if !st.stream || !st.outStarted {
+                // 与BOM相同, 每次独立的转换从初始移位状态开始解码及编码,
+                // 重新输出ISO-2022-KR的ESC $ ) C等指定序列
+                st.decMode, st.encMode = 0, 0
+                if !count && len(to) < len(dst.bom) {
+                        return fail(i, errShortBuf)
+                }
+                copy(to, dst.bom)
+                j += len(dst.bom)
+                st.outStarted = true
+        }
+        var lost []lossEntry
+        var omitted []omitEntry
+        // 编码一个字符, 写入to[j:]
+        put := func(c pivotChar) error {
+                encMode := st.encMode
+                w := scratch
+                if !count {
+                        w = to[j:]
+                }
+                var m int
+                code, err := puaReplace(st, c.code)
+                if err == nil {
+                        m, err = encodeSeq(dst, st, code, w)
+                }
+                if err != nil && err != errShortBuf && st.omit {
+                        omitted = append(omitted, omitEntry{c.off - len(saved.pending), err})
+                        return nil
+                }
+                if err != nil {
+                        return err
+                }
+                if st.lossReport != nil && (c.changed || !roundTrip(src, dst, st, code, from[c.off:c.off+c.n], w[:m], c.decMode, encMode)) {
+                        lost = append(lost, lossEntry{c.off - len(saved.pending), code})
+                }
+                j += m
+                return nil
+        }
+        // 有处理阶段时先解码全部输入
+        var chars []pivotChar
+        for i < len(from) {
+                if st.ascii && len(st.stages) == 0 && from[i] < 0x80 {
+                        n := asciiPrefix(from[i:])
+                        if !count {
+                                if len(to)-j < n {
+                                        return fail(i, errShortBuf)
+                                }
+                                copy(to[j:], from[i:i+n])
+                        }
+                        i += n
+                        j += n
+                        continue
+                }
+                decMode := st.decMode
+                code, n, err := src.decode(st, from[i:])
+                if err == errShortSrc && st.stream {
+                        st.pending = append([]byte(nil), from[i:]...)
+                        break
+                }
+                if err == errShortSrc && st.omit {
+                        omitted = append(omitted, omitEntry{i - len(saved.pending), err})
+                        i = len(from)
+                        break
+                }
+                if err != nil && st.omit {
+                        omitted = append(omitted, omitEntry{i - len(saved.pending), err})
+                        i++
+                        continue
+                }
+                if err != nil {
+                        return fail(i, err)
+                }
+                if code == noCode {
+                        i += n
+                        continue
+                }
+                if dst.combine && i+n < len(from) {
+                        la := *st
+                        if next, n2, err := src.decode(&la, from[i+n:]); err == nil && next <= 0xffffffff {
+                                if _, ok := st.encTbl[code<<32|next]; ok {
+                                        *st = la
+                                        code = code<<32 | next
+                                        n += n2
+                                }
+                        }
+                }
+                c := pivotChar{code: code, off: i, n: n, decMode: decMode}
+                i += n
+                if len(st.stages) > 0 {
+                        chars = append(chars, c)
+                        continue
+                }
+                if err = put(c); err != nil {
+                        return fail(c.off, err)
+                }
+        }
+        if len(st.stages) > 0 {
+                for _, fn := range st.stages {
+                        chars = fn(chars)
+                }
+                for _, c := range chars {
+                        if err := put(c); err != nil {
+                                return fail(c.off, err)
+                        }
+                }
+        }
+        if dst.reset != nil {
+                w := scratch
+                if !count {
+                        w = to[j:]
+                }
+                m, err := dst.reset(st, w)
+                if err != nil {
+                        return fail(i, err)
+                }
+                j += m
+        }
+        if i > 0 {
+                st.inStarted = true
+        }
+        for _, l := range lost {
+                st.lossReport(l.off, l.code)
+        }
+        if st.omitReport != nil {
+                for _, o := range omitted {
+                        st.omitReport(o.off, o.err)
+                }
+        }
+        return j, nil
+}
+
+// 编码一个码点或打包的组合字符序列, 目标字符集没有对应的组合字符时逐个码点编码
+func encodeSeq(dst *charset, st *codeState, code uint64, to []byte) (int, error) {
+        if code <= 0xffffffff {
+                return dst.encode(st, code, to)
+        }
+        if _, ok := st.encTbl[code]; ok && dst.combine {
+                return dst.encode(st, code, to)
+        }
+        m, err := dst.encode(st, code>>32, to)
+        if err != nil {
+                return 0, err
+        }
+        m2, err := dst.encode(st, code&0xffffffff, to[m:])
+        if err != nil {
+                return 0, err
+        }
+        return m + m2, nil
+}
+
+func decodeUTF8(st *codeState, from []byte) (uint64, int, error) {
+        var code uint64
+        var n int
+        switch {
+        case 0x80&from[0] == 0x00:
+                return uint64(from[0]), 1, nil
+        case 0xe0&from[0] == 0xc0:
+                code, n = uint64(from[0]&0x1f), 2
+        case 0xf0&from[0] == 0xe0:
+                code, n = uint64(from[0]&0x0f), 3
+        case 0xf8&from[0] == 0xf0:
+                code, n = uint64(from[0]&0x07), 4
+        case 0xfc&from[0] == 0xf8:
+                code, n = uint64(from[0]&0x03), 5
+        case 0xfe&from[0] == 0xfc:
+                code, n = uint64(from[0]&0x01), 6
+        default:
+                return 0, 0, fmt.Errorf("无效UTF-8字符[0x%x]", from[0])
+        }
+        for k := 1; k < n; k++ {
+                if k >= len(from) {
+                        return 0, 0, errShortSrc
+                }
+                if from[k]&0xc0 != 0x80 {
+                        return 0, 0, fmt.Errorf("无效UTF-8字符[0x%x]", from[k])
+                }
+                code = code<<6 | uint64(from[k]&0x3f)
+        }
+        return code, n, nil
+}
+
+func encodeUTF8(st *codeState, code uint64, to []byte) (int, error) {
+        var n int
+        var lead byte
+        switch {
+        case code < 0x00000080:
+                if len(to) < 1 {
+                        return 0, errShortBuf
+                }
+                to[0] = byte(code)
+                return 1, nil
+        case code < 0x00000800:
+                n, lead = 2, 0xc0
+        case code < 0x00010000:
+                n, lead = 3, 0xe0
+        case code < 0x00200000:
+                n, lead = 4, 0xf0
+        case code < 0x04000000:
+                n, lead = 5, 0xf8
+        case code < 0x80000000:
+                n, lead = 6, 0xfc
+        default:
+                return 0, fmt.Errorf("非法字符[0x%x]", code)
+        }
+        if len(to) < n {
+                return 0, errShortBuf
+        }
+        for k := n - 1; k > 0; k-- {
+                to[k] = 0x80 | byte(code&0x3f)
+                code >>= 6
+        }
+        to[0] = lead | byte(code)
+        return n, nil
+}
+
+// GBK/GB2312/GB18030共用同一映射表, 单字节为ASCII, 多字节字符的首字节及尾字节范围
+// 与ValidGB18030相同(见gbCharLen)
+func decodeGBK(st *codeState, from []byte) (uint64, int, error) {
+        var tmpGbk uint64
+        if from[0]&0x80 == 0 {
+                return uint64(from[0]), 1, nil
+        }
+        n := gbCharLen(from, true)
+        switch n {
+        case 2:
+                tmpGbk = uint64(from[0])<<8 | uint64(from[1])
+        case 4:
+                tmpGbk = uint64(from[0])<<24 | uint64(from[1])<<16 | uint64(from[2])<<8 | uint64(from[3])
+        default:
+                // 已有的字节都在范围内时为不完整的字符, 留待下次调用
+                if from[0] > 0x80 && from[0] < 0xff && (len(from) == 1 || len(from) < 4 &&
+                        from[1] >= 0x30 && from[1] <= 0x39 && (len(from) == 2 || from[2] > 0x80 && from[2] < 0xff)) {
+                        return 0, 0, errShortSrc
+                }
+                return 0, 0, fmt.Errorf("非法GBK编码[0x%x]", from[0])
+        }
+        if v, ok := st.decTbl[tmpGbk]; ok {
+                return v, n, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", tmpGbk)
+}
+
+func encodeGBK(st *codeState, code uint64, to []byte) (int, error) {
+        tmpGbk, ok := st.encTbl[code]
+        if !ok {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        switch {
+        case tmpGbk < 0x80:
+                if len(to) < 1 {
+                        return 0, errShortBuf
+                }
+                to[0] = byte(tmpGbk)
+                return 1, nil
+        case tmpGbk < 0x10000:
+                if len(to) < 2 {
+                        return 0, errShortBuf
+                }
+                to[0] = byte(tmpGbk >> 8)
+                to[1] = byte(tmpGbk)
+                return 2, nil
+        case tmpGbk < 0x100000000:
+                if len(to) < 4 {
+                        return 0, errShortBuf
+                }
+                to[0] = byte(tmpGbk >> 24)
+                to[1] = byte(tmpGbk >> 16)
+                to[2] = byte(tmpGbk >> 8)
+                to[3] = byte(tmpGbk)
+                return 4, nil
+        }
+        return 0, fmt.Errorf("非法对应字符[0x%x]", tmpGbk)
+}
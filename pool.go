@@ -0,0 +1,51 @@
+package better
+
+import "sync"
+
+// 复用输出缓冲区的转换接口, 用于高频调用: 缓冲区足够大时转换本身不分配内存
+// (出错时生成错误信息除外). 经Unicode中转的转换器在以下情况下仍会分配: 流式转换的
+// 输入末尾有不完整的字符需保留到下次调用, 使用WithNormalization等处理阶段, WithLossReport或
+// WithOmitInvalid有需要报告的字符, 以及EncodedLen计数.
+
+// 将c转换src的结果追加到dst之后, 返回追加后的切片. dst容量不足时重新分配,
+// 调用者复用返回的切片(如dst[:0])即可避免再次分配.
+func AppendConvert(c Converter, dst, src []byte) ([]byte, error) {
+        need := len(dst) + c.MaxEncodedLen(len(src))
+        if cap(dst) < need {
+                buf := make([]byte, len(dst), need)
+                copy(buf, dst)
+                dst = buf
+        }
+        n, err := c.Convert(src, dst[len(dst):need])
+        if err != nil {
+                return dst, err
+        }
+        return dst[:len(dst)+n], nil
+}
+
+// 放回池中的缓冲区容量上限, 更大的缓冲区(转换大数据时分配)不放回, 以免长期占用内存
+const maxPooledBuf = 64 << 10
+
+// 转换缓冲区池, 存放*[]byte以免Put时分配
+var g_BufPool = sync.Pool{
+        New: func() interface{} {
+                buf := make([]byte, 0, 4096)
+                return &buf
+        },
+}
+
+// 使用缓冲区池中的缓冲区以c转换src, 并以转换结果调用fn. out只在fn执行期间有效,
+// fn返回后缓冲区放回池中(超过64KB的不放回), 需要保留结果时应复制. 转换失败时不调用fn.
+func ConvertWith(c Converter, src []byte, fn func(out []byte) error) error {
+        bp := g_BufPool.Get().(*[]byte)
+        out, err := AppendConvert(c, (*bp)[:0], src)
+        // 输出超过上限时丢弃为其分配的缓冲区, 放回池中的仍是原来的缓冲区
+        if cap(out) <= maxPooledBuf {
+                *bp = out[:0]
+        }
+        defer g_BufPool.Put(bp)
+        if err != nil {
+                return err
+        }
+        return fn(out)
+}
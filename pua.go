@@ -0,0 +1,128 @@
+package better
+
+import "fmt"
+
+// 私用区(PUA)及GBK用户自定义区(UDA)的处理策略.
+// 内置GBK映射表按GB18030-2005: 用户自定义区0xAAA1~0xAFFE, 0xF8A1~0xFEFE, 0xA140~0xA7A0
+// 依次对应U+E000~U+E765(与CP936相同), GB2312/GBK中的80个空位对应U+E766~U+E864,
+// 其余私用区码点对应四字节编码.
+
+type PUAPolicy int
+
+const (
+        PUATable        PUAPolicy = iota // 按内置映射表(默认)
+        PUACP936                         // 按CP936: 用户自定义区按算法对应U+E000~U+E765, 私用区码点不使用四字节编码
+        PUAGB18030_2022                  // 按GB18030-2022: 18个编码由私用区改为对应标准字符, 原私用区码点改用四字节编码
+        PUAReject                        // 私用区字符(U+E000~U+F8FF, U+F0000~U+10FFFF)一律视为错误
+        PUASubstitute                    // 私用区字符替换为U+FFFD, 目标编码不是Unicode编码时替换为'?'
+)
+
+func (p PUAPolicy) String() string {
+        switch p {
+        case PUATable:
+                return "内置映射表"
+        case PUACP936:
+                return "CP936"
+        case PUAGB18030_2022:
+                return "GB18030-2022"
+        case PUAReject:
+                return "拒绝"
+        case PUASubstitute:
+                return "替换"
+        }
+        return fmt.Sprintf("未知策略(%d)", int(p))
+}
+
+// 设置私用区策略, 解码和编码两个方向使用同一策略.
+// PUACP936及PUAGB18030_2022只适用于GBK系列编码(GBK, GB2312, GB18030, HZ-GB-2312, ISO-2022-CN),
+// PUAReject及PUASubstitute适用于任何编码.
+func WithPUAPolicy(p PUAPolicy) Option {
+        return func(o *options) {
+                o.pua = p
+        }
+}
+
+// GB18030-2022中由私用区改为对应标准字符的双字节编码
+var g_GB18030_2022 = map[uint64]uint64{
+        0xa6d9: 0xfe10, 0xa6da: 0xfe12, 0xa6db: 0xfe11, 0xa6dc: 0xfe13,
+        0xa6dd: 0xfe14, 0xa6de: 0xfe15, 0xa6df: 0xfe16, 0xa6ec: 0xfe17,
+        0xa6ed: 0xfe18, 0xa6f3: 0xfe19, 0xfe59: 0x9fb4, 0xfe61: 0x9fb5,
+        0xfe66: 0x9fb6, 0xfe67: 0x9fb7, 0xfe6d: 0x9fb8, 0xfe7e: 0x9fb9,
+        0xfe90: 0x9fba, 0xfea0: 0x9fbb,
+}
+
+func isPUA(code uint64) bool {
+        return (code >= 0xe000 && code <= 0xf8ff) || (code >= 0xf0000 && code <= 0x10ffff)
+}
+
+// 用户自定义区的编码按CP936的算法对应的码点, 不在用户自定义区时ok为false
+func udaToPUA(code uint64) (uint64, bool) {
+        lead, trail := code>>8, code&0xff
+        switch {
+        case code > 0xffff:
+                return 0, false
+        case lead >= 0xaa && lead <= 0xaf && trail >= 0xa1 && trail <= 0xfe:
+                return 0xe000 + (lead-0xaa)*94 + (trail - 0xa1), true
+        case lead >= 0xf8 && lead <= 0xfe && trail >= 0xa1 && trail <= 0xfe:
+                return 0xe234 + (lead-0xf8)*94 + (trail - 0xa1), true
+        case lead >= 0xa1 && lead <= 0xa7 && trail >= 0x40 && trail <= 0xa0 && trail != 0x7f:
+                if trail > 0x7f {
+                        trail--
+                }
+                return 0xe4c6 + (lead-0xa1)*96 + (trail - 0x40), true
+        }
+        return 0, false
+}
+
+// 按PUACP936或PUAGB18030_2022修改GBK的映射表, 返回新的映射表
+func puaTables(dec, enc map[uint64]uint64, p PUAPolicy) (map[uint64]uint64, map[uint64]uint64, error) {
+        m := make(map[uint64]uint64)
+        switch p {
+        case PUACP936:
+                for lead := uint64(0xa1); lead <= 0xfe; lead++ {
+                        for trail := uint64(0x40); trail <= 0xfe; trail++ {
+                                if u, ok := udaToPUA(lead<<8 | trail); ok {
+                                        m[lead<<8|trail] = u
+                                }
+                        }
+                }
+        case PUAGB18030_2022:
+                // 与原来对应这些标准字符的四字节编码互换
+                for code, u := range g_GB18030_2022 {
+                        m[code] = u
+                        if old, ok := enc[u]; ok {
+                                m[old] = dec[code]
+                        }
+                }
+        }
+        dec, enc, err := overrideTables(dec, enc, m)
+        if err != nil {
+                return nil, nil, err
+        }
+        if p == PUACP936 {
+                for code, u := range dec {
+                        if code > 0xffff && isPUA(u) {
+                                delete(dec, code)
+                        }
+                }
+                for u, code := range enc {
+                        if code > 0xffff && isPUA(u) {
+                                delete(enc, u)
+                        }
+                }
+        }
+        return dec, enc, nil
+}
+
+// 按PUAReject或PUASubstitute处理私用区字符
+func puaReplace(st *codeState, code uint64) (uint64, error) {
+        switch {
+        case !isPUA(code):
+                return code, nil
+        case st.pua == PUAReject:
+                return 0, fmt.Errorf("不允许私用区字符[0x%x]", code)
+        case st.pua == PUASubstitute:
+                return st.puaSubst, nil
+        }
+        return code, nil
+}
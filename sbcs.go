@@ -0,0 +1,91 @@
+package better
+
+import (
+        "fmt"
+        "strings"
+)
+
+// 单字节编码: ISO-8859-x, Windows-125x, KOI8-R/U, CP437/850/866.
+// 映射表位于sbcs目录, 每种编码只有字节到Unicode的一个映射表, 反向映射加载时生成.
+
+// 编码名称的别名, 名称均为大写
+var g_Aliases = map[string]string{
+        "UTF8":        "UTF-8",
+        "CP936":       "GBK",
+        "GB-2312":     "GB2312",
+        "EUC-CN":      "GB2312",
+        "UTF16":       "UTF-16",
+        "UTF16LE":     "UTF-16LE",
+        "UTF16BE":     "UTF-16BE",
+        "UTF32":       "UTF-32",
+        "UTF32LE":     "UTF-32LE",
+        "UTF32BE":     "UTF-32BE",
+        "UCS2LE":      "UCS-2LE",
+        "UCS2BE":      "UCS-2BE",
+        "BIG5HKSCS":   "BIG5-HKSCS",
+        "SJIS":        "SHIFT_JIS",
+        "SHIFT-JIS":   "SHIFT_JIS",
+        "WINDOWS-31J": "CP932",
+        "EUCJP":       "EUC-JP",
+        "EUCKR":       "EUC-KR",
+        "UHC":         "CP949",
+        "LATIN1":      "ISO-8859-1",
+        "LATIN2":      "ISO-8859-2",
+        "LATIN9":      "ISO-8859-15",
+        "CYRILLIC":    "ISO-8859-5",
+        "IBM437":      "CP437",
+        "IBM850":      "CP850",
+        "IBM866":      "CP866",
+        "IBM037":      "CP037",
+        "EBCDIC-US":   "CP037",
+        "IBM500":      "CP500",
+        "IBM1047":     "CP1047",
+        "IBM935":      "IBM-935",
+        "IBM1388":     "IBM-1388",
+        "HZ":          "HZ-GB-2312",
+}
+
+// 将编码名称规范为g_Charsets中使用的名称
+func charsetName(name string) string {
+        name = strings.ToUpper(strings.TrimSpace(name))
+        if v, ok := g_Aliases[name]; ok {
+                return v
+        }
+        if strings.HasPrefix(name, "CP125") {
+                return "WINDOWS-" + name[2:]
+        }
+        if strings.HasPrefix(name, "ISO8859-") || strings.HasPrefix(name, "ISO_8859-") {
+                return "ISO-8859-" + name[strings.Index(name, "-")+1:]
+        }
+        return name
+}
+
+func decodeSBCS(st *codeState, from []byte) (uint64, int, error) {
+        if v, ok := st.decTbl[uint64(from[0])]; ok {
+                return v, 1, nil
+        }
+        return 0, 0, fmt.Errorf("未找到对应字符[0x%x]", from[0])
+}
+
+func encodeSBCS(st *codeState, code uint64, to []byte) (int, error) {
+        v, ok := st.encTbl[code]
+        if !ok {
+                return 0, fmt.Errorf("未找到对应字符[0x%x]", code)
+        }
+        if len(to) < 1 {
+                return 0, errShortBuf
+        }
+        to[0] = byte(v)
+        return 1, nil
+}
+
+// 由字符集到Unicode的映射表生成反向映射, 多个字节对应同一码点时取最小的字节
+func reverseTable(tbl map[uint64]uint64) map[uint64]uint64 {
+        rev := make(map[uint64]uint64, len(tbl))
+        for k, v := range tbl {
+                if old, ok := rev[v]; !ok || k < old {
+                        rev[v] = k
+                }
+        }
+        return rev
+}
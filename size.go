@@ -0,0 +1,193 @@
+package better
+
+import "strings"
+
+// 输出长度的估算: 源编码的每类字符(n字节解码为codes个不大于max的码点)按目标编码
+// 编码该范围内码点的最大长度计算输出, 各类字符中输出与输入之比最大者即为每个输入字节的
+// 最大输出. 使用映射表的编码由映射表得出各类字符, 不需要逐个编码列出.
+
+// 一类字符: n字节解码为codes个不大于max的码点
+type charKind struct {
+        n     int
+        codes int
+        max   uint64
+}
+
+// 有移位状态的编码: 字符前最多输出shift字节的转义序列或SO/SI, ASCII字符最多编码为ascii字节,
+// 其它字符为双字节; fixed为流开头的指定序列及结尾复位到ASCII的固定开销
+type shiftCost struct {
+        shift, ascii, fixed int
+}
+
+var g_ShiftCosts = map[string]shiftCost{
+        "ISO-2022-JP": {3, 1, 3},
+        "ISO-2022-KR": {1, 1, 5},
+        "ISO-2022-CN": {5, 1, 1},
+        "HZ-GB-2312":  {2, 2, 2}, // "~"编码为"~~"
+        "IBM-935":     {1, 1, 1},
+        "IBM-1388":    {1, 1, 1},
+}
+
+// 编码的字节数
+func byteLen(v uint64) int {
+        n := 1
+        for v >= 0x100 {
+                v >>= 8
+                n++
+        }
+        return n
+}
+
+// 源编码的各类字符, decTbl为其解码映射表
+func decodeKinds(cs *charset, name string, decTbl map[uint64]uint64) []charKind {
+        switch {
+        case name == "UTF-8":
+                return []charKind{{1, 1, 0x7f}, {2, 1, 0x7ff}, {3, 1, 0xffff}, {4, 1, 0x10ffff}}
+        case strings.HasPrefix(name, "UTF-16"):
+                return []charKind{{2, 1, 0xffff}, {4, 1, 0x10ffff}}
+        case strings.HasPrefix(name, "UCS-2"):
+                return []charKind{{2, 1, 0xffff}}
+        case strings.HasPrefix(name, "UTF-32"):
+                return []charKind{{4, 1, 0x10ffff}}
+        }
+        _, shifted := g_ShiftCosts[name]
+        // 按(字节数, 码点数)归类, 取各类的最大码点
+        kinds := map[[2]int]uint64{}
+        if cs.ascii || shifted {
+                kinds[[2]int{1, 1}] = 0x7f
+        }
+        if name == "ISO-2022-JP" {
+                // JIS X 0201罗马字中0x5C, 0x7E解码为U+00A5, U+203E
+                kinds[[2]int{1, 1}] = 0x203e
+        }
+        for k, v := range decTbl {
+                n, codes := byteLen(k), 1
+                if shifted && n > 2 {
+                        // ISO-2022, HZ使用EUC形式的映射表, 实际在SO或转义序列之后以双字节传输
+                        n = 2
+                }
+                if v > 0xffffffff {
+                        codes = 2
+                        if v>>32 > v&0xffffffff {
+                                v >>= 32
+                        } else {
+                                v &= 0xffffffff
+                        }
+                }
+                if key := [2]int{n, codes}; v > kinds[key] {
+                        kinds[key] = v
+                }
+        }
+        ret := make([]charKind, 0, len(kinds))
+        for key, max := range kinds {
+                ret = append(ret, charKind{key[0], key[1], max})
+        }
+        return ret
+}
+
+// 目标编码编码不大于各max的码点最多输出的字节数, encTbl为其编码映射表
+func encodeWidths(cs *charset, name string, encTbl map[uint64]uint64, maxes []uint64) []int {
+        ws := make([]int, len(maxes))
+        if cost, ok := g_ShiftCosts[name]; ok {
+                for k, max := range maxes {
+                        ws[k] = cost.shift + 2
+                        if max < 0x80 {
+                                ws[k] = cost.shift + cost.ascii
+                        }
+                }
+                return ws
+        }
+        for k, max := range maxes {
+                switch {
+                case name == "UTF-8":
+                        ws[k] = 4
+                        switch {
+                        case max < 0x80:
+                                ws[k] = 1
+                        case max < 0x800:
+                                ws[k] = 2
+                        case max < 0x10000:
+                                ws[k] = 3
+                        }
+                case strings.HasPrefix(name, "UTF-16"):
+                        ws[k] = 4
+                        if max < 0x10000 {
+                                ws[k] = 2
+                        }
+                case strings.HasPrefix(name, "UCS-2"):
+                        ws[k] = 2
+                case strings.HasPrefix(name, "UTF-32"):
+                        ws[k] = 4
+                case max < 0x80 && cs.ascii:
+                        ws[k] = 1
+                }
+        }
+        if encTbl == nil {
+                return ws
+        }
+        // 无法编码的字符出错, 跳过或替换为"?", 均不超过映射表中的最大长度
+        for code, v := range encTbl {
+                n := byteLen(v)
+                for k, max := range maxes {
+                        if code <= max && n > ws[k] {
+                                ws[k] = n
+                        }
+                }
+        }
+        return ws
+}
+
+// 每个输入字节最多输出的字节数, 以分数num/den表示; 不能确定时den为0
+func (st *codeState) maxRatio(s, d *charset, src, dst string) (num, den int) {
+        if s == nil || d == nil {
+                return 0, 0
+        }
+        expand := st.expand
+        if expand < 1 {
+                expand = 1
+        }
+        kinds := decodeKinds(s, src, st.decTbl)
+        maxes := make([]uint64, len(kinds))
+        for k, kind := range kinds {
+                maxes[k] = kind.max
+                if len(st.stages) > 0 {
+                        // 处理阶段可能把字符换为任意字符
+                        maxes[k] = 0x10ffff
+                }
+        }
+        ws := encodeWidths(d, dst, st.encTbl, maxes)
+        for k, kind := range kinds {
+                out := kind.codes * expand * ws[k]
+                if den == 0 || out*den > num*kind.n {
+                        num, den = out, kind.n
+                }
+        }
+        return num, den
+}
+
+// 将num/den向上取整
+func perByte(num, den int) int {
+        if den == 0 {
+                return 0
+        }
+        return (num + den - 1) / den
+}
+
+// 经Unicode中转时上次调用遗留的不完整字符最多的字节数
+const maxPending = 3
+
+// 目标编码的固定开销: BOM, 流开头的指定序列及结尾复位到ASCII的转义序列
+func fixedCost(d *charset, name string) int {
+        if d == nil {
+                return 0
+        }
+        return len(d.bom) + g_ShiftCosts[name].fixed
+}
+
+// 以每字节最多输出num/den字节计算, n字节输入及pend字节遗留的不完整字符最多输出的字节数
+func maxEncodedLen(num, den, pend, fixed, n int) int {
+        if den == 0 {
+                return 0
+        }
+        return ((n+pend)*num+den-1)/den + fixed
+}
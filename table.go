@@ -0,0 +1,154 @@
+package better
+
+import (
+        "bytes"
+        "encoding/binary"
+        "errors"
+        "fmt"
+        "hash/crc32"
+        "io"
+        "sort"
+)
+
+// 映射表文件格式(版本1), 所有整数均为小端序:
+//
+//	偏移  长度  内容
+//	0     8     魔数"ICONVTBL"
+//	8     4     版本号, 当前为1
+//	12    4     方向: 1为字符集编码到Unicode(TableDecode), 2为Unicode到字符集编码(TableEncode)
+//	16    32    字符集名称(ASCII), 不足32字节补0
+//	48    8     项数N
+//	56    4     数据部分的CRC-32(IEEE)
+//	60    4     头部前60字节的CRC-32(IEEE)
+//	64    16*N  各项依次为8字节的键和8字节的值, 按键严格升序排列
+//
+// 头部长度为64字节, 各项按16字节对齐, 格式本身允许其它实现将文件映射到内存后直接按键
+// 二分查找. 本库不这样做: ReadTable及加载映射表时读入整个文件并解码为map[uint64]uint64
+// (自定义映射, 私用区策略及单字节编码的反向映射都在map上生成), 每个进程都要付出解析时间及
+// 与映射表大小相当的堆内存; 同一进程中的转换器共享已加载的映射表.
+// 组合字符序列的码点以 首码点<<32|次码点 表示.
+
+type TableDir uint32
+
+const (
+        TableDecode TableDir = 1 // 字符集编码到Unicode
+        TableEncode TableDir = 2 // Unicode到字符集编码
+)
+
+const (
+        tableMagic      = "ICONVTBL"
+        tableVersion    = 1
+        tableHeaderSize = 64
+        tableNameSize   = 32
+        tableEntrySize  = 16
+)
+
+func (d TableDir) String() string {
+        switch d {
+        case TableDecode:
+                return "编码到Unicode"
+        case TableEncode:
+                return "Unicode到编码"
+        }
+        return fmt.Sprintf("未知方向(%d)", uint32(d))
+}
+
+// 以映射表文件格式写出tbl, 相同的内容总是生成相同的文件
+func WriteTable(w io.Writer, name string, dir TableDir, tbl map[uint64]uint64) error {
+        if len(name) == 0 || len(name) > tableNameSize {
+                return fmt.Errorf("字符集名称[%s]长度应为1~%d字节", name, tableNameSize)
+        }
+        if dir != TableDecode && dir != TableEncode {
+                return fmt.Errorf("映射表方向错误[%d]", uint32(dir))
+        }
+        keys := make([]uint64, 0, len(tbl))
+        for k := range tbl {
+                keys = append(keys, k)
+        }
+        sort.Slice(keys, func(i, j int) bool { return keys[i] < keys[j] })
+        buf := make([]byte, tableHeaderSize+tableEntrySize*len(keys))
+        data := buf[tableHeaderSize:]
+        for i, k := range keys {
+                binary.LittleEndian.PutUint64(data[i*tableEntrySize:], k)
+                binary.LittleEndian.PutUint64(data[i*tableEntrySize+8:], tbl[k])
+        }
+        copy(buf, tableMagic)
+        binary.LittleEndian.PutUint32(buf[8:], tableVersion)
+        binary.LittleEndian.PutUint32(buf[12:], uint32(dir))
+        copy(buf[16:16+tableNameSize], name)
+        binary.LittleEndian.PutUint64(buf[48:], uint64(len(keys)))
+        binary.LittleEndian.PutUint32(buf[56:], crc32.ChecksumIEEE(data))
+        binary.LittleEndian.PutUint32(buf[60:], crc32.ChecksumIEEE(buf[:60]))
+        _, err := w.Write(buf)
+        return err
+}
+
+// 读取映射表文件, 校验魔数, 版本, 长度, CRC及键的顺序, 返回字符集名称, 方向及映射表.
+// 全部项解码到返回的map中, 不保留对文件内容的引用
+func ReadTable(r io.Reader) (name string, dir TableDir, tbl map[uint64]uint64, err error) {
+        data, err := io.ReadAll(r)
+        if err != nil {
+                return "", 0, nil, err
+        }
+        return parseTable(data)
+}
+
+func parseTable(buf []byte) (string, TableDir, map[uint64]uint64, error) {
+        if len(buf) < tableHeaderSize || string(buf[:8]) != tableMagic {
+                return "", 0, nil, errors.New("不是映射表文件(魔数不符)")
+        }
+        if crc32.ChecksumIEEE(buf[:60]) != binary.LittleEndian.Uint32(buf[60:]) {
+                return "", 0, nil, errors.New("头部CRC校验失败")
+        }
+        if v := binary.LittleEndian.Uint32(buf[8:]); v != tableVersion {
+                return "", 0, nil, fmt.Errorf("不支持的版本[%d]", v)
+        }
+        dir := TableDir(binary.LittleEndian.Uint32(buf[12:]))
+        if dir != TableDecode && dir != TableEncode {
+                return "", 0, nil, fmt.Errorf("映射表方向错误[%d]", uint32(dir))
+        }
+        name := string(bytes.TrimRight(buf[16:16+tableNameSize], "\x00"))
+        n := binary.LittleEndian.Uint64(buf[48:])
+        data := buf[tableHeaderSize:]
+        if uint64(len(data))%tableEntrySize != 0 || uint64(len(data))/tableEntrySize != n {
+                return "", 0, nil, fmt.Errorf("文件长度与项数[%d]不符", n)
+        }
+        if crc32.ChecksumIEEE(data) != binary.LittleEndian.Uint32(buf[56:]) {
+                return "", 0, nil, errors.New("数据CRC校验失败")
+        }
+        tbl := make(map[uint64]uint64, n)
+        var last uint64
+        for i := 0; i < len(data); i += tableEntrySize {
+                k := binary.LittleEndian.Uint64(data[i:])
+                if i > 0 && k <= last {
+                        return "", 0, nil, fmt.Errorf("第%d项的键[0x%x]未按升序排列", i/tableEntrySize, k)
+                }
+                tbl[k] = binary.LittleEndian.Uint64(data[i+8:])
+                last = k
+        }
+        return name, dir, tbl, nil
+}
+
+// 加载字符集的解码映射表, 不使用映射表时返回nil. 成功时引用cs.decFile,
+// 用完后以releaseTables(tableFiles(cs.decFile))释放
+func (cs *charset) loadDec() (map[uint64]uint64, error) {
+        if cs.decFile == "nil" {
+                return nil, nil
+        }
+        return loadTable(cs.decFile, cs.table, TableDecode)
+}
+
+// 加载字符集的编码映射表, reverse为true时由解码映射表反转生成. 成功时引用cs.encFile
+func (cs *charset) loadEnc() (map[uint64]uint64, error) {
+        if cs.encFile == "nil" {
+                return nil, nil
+        }
+        if !cs.reverse {
+                return loadTable(cs.encFile, cs.table, TableEncode)
+        }
+        tbl, err := loadTable(cs.encFile, cs.table, TableDecode)
+        if err != nil {
+                return nil, err
+        }
+        return reverseTable(tbl), nil
+}
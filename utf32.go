@@ -0,0 +1,184 @@
+package better
+
+import (
+        "encoding/binary"
+        "fmt"
+)
+
+// UTF-16(含代理对), UTF-32及UCS-2的逐字符编解码.
+// BOM规则与UTF-16相同: 编码时输出BOM, 解码时若有BOM则跳过.
+
+// 判断UTF-32字符串的字节序, 返回UTF32_LE_UTF8_IDX或UTF32_BE_UTF8_IDX.
+// 有BOM时以BOM为准(bom为true); 无BOM时根据每4字节中首尾字节为零的
+// 次数推断(码点不超过0x10FFFF, 最高字节必为零), 无法区分时视为小端.
+func DetectUTF32(from []byte) (idx CODING_IDX, bom bool) {
+        if len(from) >= 4 {
+                switch {
+                case from[0] == 0xff && from[1] == 0xfe && from[2] == 0x00 && from[3] == 0x00:
+                        return UTF32_LE_UTF8_IDX, true
+                case from[0] == 0x00 && from[1] == 0x00 && from[2] == 0xfe && from[3] == 0xff:
+                        return UTF32_BE_UTF8_IDX, true
+                }
+        }
+        headZero := 0
+        tailZero := 0
+        for i := 0; i+3 < len(from) && i < 4096; i += 4 {
+                if from[i] == 0 {
+                        headZero++
+                }
+                if from[i+3] == 0 {
+                        tailZero++
+                }
+        }
+        if headZero > tailZero {
+                return UTF32_BE_UTF8_IDX, false
+        }
+        return UTF32_LE_UTF8_IDX, false
+}
+
+func detectUTF16(from []byte) string {
+        if idx, _ := DetectUTF16(from); idx == UTF16_BE_UTF8_IDX {
+                return "UTF-16BE"
+        }
+        return "UTF-16LE"
+}
+
+func detectUTF32(from []byte) string {
+        if idx, _ := DetectUTF32(from); idx == UTF32_BE_UTF8_IDX {
+                return "UTF-32BE"
+        }
+        return "UTF-32LE"
+}
+
+func decodeUTF16(order binary.ByteOrder, from []byte) (uint64, int, error) {
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        tmpUnicode := uint64(order.Uint16(from))
+        switch {
+        case tmpUnicode < 0xd800 || tmpUnicode > 0xdfff:
+                return tmpUnicode, 2, nil
+        case tmpUnicode < 0xdc00:
+                if len(from) < 4 {
+                        return 0, 0, errShortSrc
+                }
+                low := uint64(order.Uint16(from[2:]))
+                if low >= 0xdc00 && low <= 0xdfff {
+                        return 0x10000 + (tmpUnicode-0xd800)<<10 + (low - 0xdc00), 4, nil
+                }
+        }
+        return 0, 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
+}
+
+func encodeUTF16(order binary.ByteOrder, code uint64, to []byte) (int, error) {
+        switch {
+        case code >= 0xd800 && code <= 0xdfff, code > 0x10ffff:
+                return 0, fmt.Errorf("非法字符[0x%x]", code)
+        case code < 0x10000:
+                if len(to) < 2 {
+                        return 0, errShortBuf
+                }
+                order.PutUint16(to, uint16(code))
+                return 2, nil
+        }
+        if len(to) < 4 {
+                return 0, errShortBuf
+        }
+        code -= 0x10000
+        order.PutUint16(to, uint16(0xd800+code>>10))
+        order.PutUint16(to[2:], uint16(0xdc00+code&0x3ff))
+        return 4, nil
+}
+
+func decodeUTF32(order binary.ByteOrder, from []byte) (uint64, int, error) {
+        if len(from) < 4 {
+                return 0, 0, errShortSrc
+        }
+        tmpUnicode := uint64(order.Uint32(from))
+        if (tmpUnicode >= 0xd800 && tmpUnicode <= 0xdfff) || tmpUnicode > 0x10ffff {
+                return 0, 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
+        }
+        return tmpUnicode, 4, nil
+}
+
+func encodeUTF32(order binary.ByteOrder, code uint64, to []byte) (int, error) {
+        if (code >= 0xd800 && code <= 0xdfff) || code > 0x10ffff {
+                return 0, fmt.Errorf("非法字符[0x%x]", code)
+        }
+        if len(to) < 4 {
+                return 0, errShortBuf
+        }
+        order.PutUint32(to, uint32(code))
+        return 4, nil
+}
+
+// UCS-2只能表示基本多文种平面, 不使用代理对
+func decodeUCS2(order binary.ByteOrder, from []byte) (uint64, int, error) {
+        if len(from) < 2 {
+                return 0, 0, errShortSrc
+        }
+        tmpUnicode := uint64(order.Uint16(from))
+        if tmpUnicode >= 0xd800 && tmpUnicode <= 0xdfff {
+                return 0, 0, fmt.Errorf("非法字符[0x%x]", tmpUnicode)
+        }
+        return tmpUnicode, 2, nil
+}
+
+func encodeUCS2(order binary.ByteOrder, code uint64, to []byte) (int, error) {
+        if (code >= 0xd800 && code <= 0xdfff) || code > 0xffff {
+                return 0, fmt.Errorf("UCS-2无法表示字符[0x%x]", code)
+        }
+        if len(to) < 2 {
+                return 0, errShortBuf
+        }
+        order.PutUint16(to, uint16(code))
+        return 2, nil
+}
+
+func decodeUTF16LE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUTF16(binary.LittleEndian, from)
+}
+
+func decodeUTF16BE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUTF16(binary.BigEndian, from)
+}
+
+func encodeUTF16LE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUTF16(binary.LittleEndian, code, to)
+}
+
+func encodeUTF16BE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUTF16(binary.BigEndian, code, to)
+}
+
+func decodeUTF32LE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUTF32(binary.LittleEndian, from)
+}
+
+func decodeUTF32BE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUTF32(binary.BigEndian, from)
+}
+
+func encodeUTF32LE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUTF32(binary.LittleEndian, code, to)
+}
+
+func encodeUTF32BE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUTF32(binary.BigEndian, code, to)
+}
+
+func decodeUCS2LE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUCS2(binary.LittleEndian, from)
+}
+
+func decodeUCS2BE(st *codeState, from []byte) (uint64, int, error) {
+        return decodeUCS2(binary.BigEndian, from)
+}
+
+func encodeUCS2LE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUCS2(binary.LittleEndian, code, to)
+}
+
+func encodeUCS2BE(st *codeState, code uint64, to []byte) (int, error) {
+        return encodeUCS2(binary.BigEndian, code, to)
+}
@@ -0,0 +1,137 @@
+package better
+
+import "encoding/binary"
+
+// 编码校验: 完整检查各字节的取值范围, 返回第一个非法字符的偏移, 全部合法时返回-1.
+// ASCII及不含代理对的UTF-16按8字节一组检查, 适合对每条消息做校验.
+
+const asciiMask = 0x8080808080808080
+
+// 返回from开头连续ASCII字节的长度
+func asciiPrefix(from []byte) int {
+        i := 0
+        for i+8 <= len(from) && binary.LittleEndian.Uint64(from[i:])&asciiMask == 0 {
+                i += 8
+        }
+        for i < len(from) && from[i] < 0x80 {
+                i++
+        }
+        return i
+}
+
+// 返回UTF-16字符串from开头连续ASCII字符(0x0000~0x007F)的字节数, 每次检查4个字符
+func utf16ASCIIPrefix(from []byte, bigEndian bool) int {
+        var mask uint64 = 0xff80ff80ff80ff80
+        if bigEndian {
+                mask = 0x80ff80ff80ff80ff
+        }
+        i := 0
+        for i+8 <= len(from) && binary.LittleEndian.Uint64(from[i:])&mask == 0 {
+                i += 8
+        }
+        for i+2 <= len(from) {
+                lo, hi := from[i], from[i+1]
+                if bigEndian {
+                        lo, hi = hi, lo
+                }
+                if hi != 0 || lo >= 0x80 {
+                        break
+                }
+                i += 2
+        }
+        return i
+}
+
+// GBK: 单字节0x00~0x7F; 双字节首字节0x81~0xFE, 次字节0x40~0x7E或0x80~0xFE
+func ValidGBK(from []byte) int {
+        return validGB(from, false)
+}
+
+// GB18030: 在GBK的基础上增加四字节编码, 第一, 三字节0x81~0xFE, 第二, 四字节0x30~0x39
+func ValidGB18030(from []byte) int {
+        return validGB(from, true)
+}
+
+func validGB(from []byte, fourByte bool) int {
+        i := 0
+        fromLen := len(from)
+        for i < fromLen {
+                if from[i] < 0x80 {
+                        i += asciiPrefix(from[i:])
+                        continue
+                }
+                n := gbCharLen(from[i:], fourByte)
+                if n == 0 {
+                        return i
+                }
+                i += n
+        }
+        return -1
+}
+
+// 返回from开头一个合法的GBK/GB18030多字节字符的长度, 不合法或不完整时返回0
+func gbCharLen(from []byte, fourByte bool) int {
+        if from[0] <= 0x80 || from[0] == 0xff || len(from) < 2 {
+                return 0
+        }
+        b := from[1]
+        switch {
+        case b >= 0x40 && b <= 0xfe && b != 0x7f:
+                return 2
+        case fourByte && b >= 0x30 && b <= 0x39:
+                if len(from) < 4 || from[2] < 0x81 || from[2] == 0xff ||
+                        from[3] < 0x30 || from[3] > 0x39 {
+                        return 0
+                }
+                return 4
+        }
+        return 0
+}
+
+// UTF-16: 长度为偶数, 高代理(0xD800~0xDBFF)后必须紧跟低代理(0xDC00~0xDFFF)
+func ValidUTF16LE(from []byte) int {
+        return validUTF16(binary.LittleEndian, from)
+}
+
+func ValidUTF16BE(from []byte) int {
+        return validUTF16(binary.BigEndian, from)
+}
+
+// 字节序未知时由DetectUTF16确定, BOM按普通字符校验
+func ValidUTF16(from []byte) int {
+        if idx, _ := DetectUTF16(from); idx == UTF16_BE_UTF8_IDX {
+                return ValidUTF16BE(from)
+        }
+        return ValidUTF16LE(from)
+}
+
+func validUTF16(order binary.ByteOrder, from []byte) int {
+        i := 0
+        fromLen := len(from) &^ 1
+        for i < fromLen {
+                //一次检查4个码元, 都不是代理时整组跳过
+                if i+8 <= fromLen {
+                        v := order.Uint64(from[i:])&0xf800f800f800f800 ^ 0xd800d800d800d800
+                        if (v-0x0001000100010001)&^v&0x8000800080008000 == 0 {
+                                i += 8
+                                continue
+                        }
+                }
+                u := order.Uint16(from[i:])
+                switch {
+                case u < 0xd800 || u > 0xdfff:
+                        i += 2
+                case u < 0xdc00 && i+4 <= fromLen:
+                        if low := order.Uint16(from[i+2:]); low < 0xdc00 || low > 0xdfff {
+                                return i
+                        }
+                        i += 4
+                default:
+                        return i
+                }
+        }
+        if fromLen != len(from) {
+                return fromLen
+        }
+        return -1
+}
@@ -0,0 +1,72 @@
+package better
+
+import "fmt"
+
+// 全角/半角转换: 全角ASCII字符U+FF01~U+FF5E与ASCII字符U+0021~U+007E一一对应,
+// 全角空格U+3000对应空格. 在解码后, 编码前进行, 与源编码及目标编码无关.
+
+type WidthForm int
+
+const (
+        WidthNone WidthForm = iota // 不转换(默认)
+        WidthHalf                  // 全角转为半角(ASCII)
+        WidthFull                  // 半角(ASCII)转为全角
+)
+
+func (f WidthForm) String() string {
+        switch f {
+        case WidthNone:
+                return "不转换"
+        case WidthHalf:
+                return "半角"
+        case WidthFull:
+                return "全角"
+        }
+        return fmt.Sprintf("未知宽度(%d)", int(f))
+}
+
+// 转换时将字符统一为全角或半角. alnumOnly为true时只转换字母和数字, 标点及空格不变.
+// 与WithNormalization同时使用时在正规化之后进行(NFKC本身会将全角字符转为半角).
+// 与WithLossReport同时使用时, 被转换的字符均报告为有损.
+func WithWidthFold(form WidthForm, alnumOnly bool) Option {
+        return func(o *options) {
+                o.width = form
+                o.widthAlnum = alnumOnly
+        }
+}
+
+func isAlnum(code uint64) bool {
+        return (code >= '0' && code <= '9') || (code >= 'A' && code <= 'Z') || (code >= 'a' && code <= 'z')
+}
+
+// 返回code转换宽度后的码点, 不需转换时返回code
+func foldWidth(code uint64, form WidthForm, alnumOnly bool) uint64 {
+        switch form {
+        case WidthHalf:
+                switch {
+                case code >= 0xff01 && code <= 0xff5e && (!alnumOnly || isAlnum(code-0xfee0)):
+                        return code - 0xfee0
+                case code == 0x3000 && !alnumOnly:
+                        return ' '
+                }
+        case WidthFull:
+                switch {
+                case code >= 0x21 && code <= 0x7e && (!alnumOnly || isAlnum(code)):
+                        return code + 0xfee0
+                case code == ' ' && !alnumOnly:
+                        return 0x3000
+                }
+        }
+        return code
+}
+
+func widthStage(form WidthForm, alnumOnly bool) stage {
+        return func(chars []pivotChar) []pivotChar {
+                for k := range chars {
+                        if code := foldWidth(chars[k].code, form, alnumOnly); code != chars[k].code {
+                                chars[k].code, chars[k].changed = code, true
+                        }
+                }
+                return chars
+        }
+}